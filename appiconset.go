@@ -0,0 +1,171 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// contentsFile is the name of the file describing an asset catalog entry.
+const contentsFile = "Contents.json"
+
+// appIconContents is the content of the Contents.json file of an Xcode asset
+// catalog icon set.
+type appIconContents struct {
+	Images []appIconImage `json:"images"`
+	Info   appIconInfo    `json:"info"`
+}
+
+type appIconImage struct {
+	Filename string `json:"filename,omitempty"`
+	Idiom    string `json:"idiom"`
+	Scale    string `json:"scale"`
+	Size     string `json:"size"`
+}
+
+type appIconInfo struct {
+	Author  string `json:"author"`
+	Version int    `json:"version"`
+}
+
+// parse returns the point size and scale of the image, or 0 values if they are
+// invalid for a macOS icon.
+func (a appIconImage) parse() (int, int) {
+	dims := strings.Split(a.Size, "x")
+	if len(dims) != 2 || dims[0] != dims[1] {
+		return 0, 0
+	}
+	points, err := strconv.Atoi(dims[0])
+	if err != nil || points <= 0 {
+		return 0, 0
+	}
+	switch a.Scale {
+	case "1x":
+		return points, 1
+	case "2x":
+		return points, 2
+	}
+	return 0, 0
+}
+
+// localName reports whether name designates a file directly in a directory.
+func localName(name string) bool {
+	return name != "." && name != ".." && !strings.ContainsAny(name, `/\`) && filepath.Base(name) == name
+}
+
+// ReadAppIconset creates a new icon based on provided options, from the images
+// of an Xcode asset catalog icon set, such as Assets.xcassets/AppIcon.appiconset.
+// Only the "mac" idiom images listed in its Contents.json file are considered.
+// They are handled like the files of ReadIconset, except that they can have any
+// name, as long as they are in the icon set directory itself. The report lists the
+// images that weren't found by their .iconset name, and the files of the images
+// the icon has no slot for.
+func ReadAppIconset(dir string, opts ...Option) (*ICNS, *IconsetReport, error) {
+	return readAppIconset(osDir(dir), opts)
+}
+
+func readAppIconset(dir iconsetDir, opts []Option) (*ICNS, *IconsetReport, error) {
+	data, err := dir.read(contentsFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	var contents appIconContents
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", contentsFile, err)
+	}
+
+	i := NewICNS(opts...)
+	report := &IconsetReport{}
+	found := make(map[string]bool)
+	for _, img := range contents.Images {
+		// entries without a file are empty wells in Xcode.
+		if img.Idiom != "mac" || img.Filename == "" {
+			continue
+		}
+		// files must be in the icon set directory itself.
+		if !localName(img.Filename) {
+			return nil, nil, fmt.Errorf("%s: invalid file name %q", contentsFile, img.Filename)
+		}
+
+		points, scale := img.parse()
+		slots := i.slotsFor(points, scale)
+		if points == 0 || len(slots) == 0 {
+			report.Unexpected = append(report.Unexpected, img.Filename)
+			continue
+		}
+
+		if err := i.load(dir, img.Filename, slots); err != nil {
+			return nil, nil, err
+		}
+		found[iconsetFile(points, scale)] = true
+	}
+
+	report.Missing = i.missing(found)
+	if len(i.assets) == 0 {
+		return nil, report, fmt.Errorf("%w in icon set", ErrNoImage)
+	}
+	return i, report, nil
+}
+
+// WriteAppIconset writes the images of the icon into the provided directory like
+// WriteIconset, along with a Contents.json file making it an Xcode asset catalog
+// icon set for the "mac" idiom. The directory is created if needed.
+func WriteAppIconset(dir string, i *ICNS, opts ...IconsetOption) ([]IconsetFile, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return WriteAppIconsetFS(osDir(dir), i, opts...)
+}
+
+// WriteAppIconsetFS is like WriteAppIconset, but creates the files at the root
+// of fsys.
+func WriteAppIconsetFS(fsys WritableFS, i *ICNS, opts ...IconsetOption) ([]IconsetFile, error) {
+	files, err := WriteIconsetFS(fsys, i, opts...)
+	if err != nil {
+		return files, err
+	}
+
+	written := make(map[string]bool, len(files))
+	for _, f := range files {
+		written[f.Name] = true
+	}
+
+	// list all the sizes, as Xcode does, leaving the missing ones empty.
+	contents := appIconContents{Info: appIconInfo{Author: "xcode", Version: 1}}
+	for _, points := range iconsetPoints {
+		for _, scale := range []int{1, 2} {
+			img := appIconImage{
+				Idiom: "mac",
+				Scale: fmt.Sprintf("%dx", scale),
+				Size:  fmt.Sprintf("%dx%d", points, points),
+			}
+			if name := iconsetFile(points, scale); written[name] {
+				img.Filename = name
+			}
+			contents.Images = append(contents.Images, img)
+		}
+	}
+
+	data, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return files, err
+	}
+	return files, fsys.WriteFile(contentsFile, append(data, '\n'))
+}
@@ -0,0 +1,155 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAppIconset(t *testing.T) {
+	t.Parallel()
+
+	icon, err := Decode(testdataFileReader(t, "mit.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := memFS{}
+	if _, err := WriteAppIconsetFS(fsys, icon, WithIconsetScales(2)); err != nil {
+		t.Fatal(err)
+	}
+	var contents appIconContents
+	if err := json.Unmarshal(fsys[contentsFile], &contents); err != nil {
+		t.Fatal(err)
+	}
+	if len(contents.Images) != 10 {
+		t.Fatalf("unexpected number of images: got %d, want 10", len(contents.Images))
+	}
+	for _, img := range contents.Images {
+		if img.Idiom != "mac" || (img.Filename != "") != (img.Scale == "2x") {
+			t.Errorf("unexpected entry %+v", img)
+		}
+		if img.Filename != "" && fsys[img.Filename] == nil {
+			t.Errorf("%s: file not written", img.Filename)
+		}
+	}
+
+	tmp, err := ioutil.TempDir("", "icns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "AppIcon.appiconset")
+	if _, err := WriteAppIconset(dir, icon); err != nil {
+		t.Fatal(err)
+	}
+	got, report, err := ReadAppIconset(dir, WithPreset(IconutilPreset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&IconsetReport{}, report); diff != "" {
+		t.Errorf("ReadAppIconset() report mismatch (-want +got):\n%s", diff)
+	}
+	if n, want := len(got.Assets()), len(icon.Assets()); n != want {
+		t.Errorf("unexpected number of assets: got %d, want %d", n, want)
+	}
+}
+
+func TestReadAppIconset(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "icns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"AppIcon-512@2x.png": "icon_512x512@2x.png",
+		"AppIcon-128.png":    "icon_128x128.png",
+		"iPhone.png":         "icon_32x32.png",
+	}
+	for name, orig := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), mustReadFile(t, filepath.Join("testdata", "mit.iconset", orig)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	contents := `{
+  "images" : [
+    { "filename" : "iPhone.png", "idiom" : "iphone", "scale" : "2x", "size" : "16x16" },
+    { "filename" : "AppIcon-128.png", "idiom" : "mac", "scale" : "1x", "size" : "128x128" },
+    { "filename" : "AppIcon-512@2x.png", "idiom" : "mac", "scale" : "2x", "size" : "512x512" },
+    { "filename" : "iPhone.png", "idiom" : "mac", "scale" : "1x", "size" : "20x20" },
+    { "idiom" : "mac", "scale" : "1x", "size" : "512x512" }
+  ],
+  "info" : { "author" : "xcode", "version" : 1 }
+}`
+	if err := ioutil.WriteFile(filepath.Join(dir, contentsFile), []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	icon, report, err := ReadAppIconset(dir, WithPreset(MinimalPreset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &IconsetReport{
+		Missing:    []string{"icon_256x256.png", "icon_512x512.png"},
+		Unexpected: []string{"iPhone.png"},
+	}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("ReadAppIconset() report mismatch (-want +got):\n%s", diff)
+	}
+	var types []OSType
+	for _, a := range icon.Assets() {
+		types = append(types, a.Type)
+	}
+	if diff := cmp.Diff([]OSType{ic07, ic10}, types); diff != "" {
+		t.Errorf("unexpected slots (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadAppIconsetTraversal(t *testing.T) {
+	t.Parallel()
+
+	root, err := ioutil.TempDir("", "icns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	secret := mustReadFile(t, filepath.Join("testdata", "mit.iconset", "icon_128x128.png"))
+	if err := ioutil.WriteFile(filepath.Join(root, "secret.png"), secret, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, "AppIcon.appiconset")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"../secret.png", "sub/../../secret.png", filepath.Join(root, "secret.png"), ".."} {
+		contents := fmt.Sprintf(`{"images": [{"filename": %q, "idiom": "mac", "scale": "1x", "size": "128x128"}]}`, name)
+		if err := ioutil.WriteFile(filepath.Join(dir, contentsFile), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if icon, _, err := ReadAppIconset(dir); err == nil {
+			t.Errorf("ReadAppIconset() with file %q read %d images, want an error", name, len(icon.Assets()))
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"image"
+	"sort"
+)
+
+// Asset describes a single image representation stored in an icon.
+type Asset struct {
+	// Type is the OSType of the chunk holding the image.
+	Type OSType
+	// Resolution is the size of the image in pixels.
+	Resolution Resolution
+	// Points is the size of the image in points, when displayed at Scale.
+	Points int
+	// Scale is the display scale factor the image is meant for (1 or 2).
+	Scale int
+	// Encoding is the payload encoding, as decoded or as it will be encoded.
+	Encoding Encoding
+	// Compatibility is the oldest OS version able to use the image.
+	Compatibility Compatibility
+	// EncodedSize is the size of the encoded payload in bytes, or 0 if unknown.
+	EncodedSize int
+	// ColorSpace is the color space the image is tagged with, in PNG data.
+	ColorSpace ColorSpace
+
+	img *img
+}
+
+// Image returns the image data, or nil if it hasn't been decoded, or can't be
+// (e.g. JPEG 2000 data).
+func (a Asset) Image() image.Image {
+	return a.img.image()
+}
+
+// Assets enumerates the image representations of the icon, sorted by resolution,
+// scale and type.
+func (i *ICNS) Assets() []Asset {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.sortedAssets()
+}
+
+func (i *ICNS) sortedAssets() []Asset {
+	res := make([]Asset, 0, len(i.assets))
+	for _, a := range i.assets {
+		res = append(res, i.asset(a))
+	}
+
+	sort.Slice(res, func(x, y int) bool {
+		if res[x].Resolution != res[y].Resolution {
+			return res[x].Resolution < res[y].Resolution
+		}
+		if res[x].Scale != res[y].Scale {
+			return res[x].Scale < res[y].Scale
+		}
+		return res[x].Type < res[y].Type
+	})
+	return res
+}
+
+func (i *ICNS) asset(a *img) Asset {
+	enc := a.encoder
+	if enc == "" {
+		// not decoded, report what Encode would do.
+		enc, _ = i.encodingFor(a.format)
+	}
+
+	return Asset{
+		Type:          a.format.code,
+		Resolution:    a.format.res,
+		Points:        int(a.format.res) / a.format.scale,
+		Scale:         a.format.scale,
+		Encoding:      enc,
+		Compatibility: a.format.compatFor(enc),
+		EncodedSize:   a.size,
+		ColorSpace:    newColorSpace(a.color),
+		img:           a,
+	}
+}
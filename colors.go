@@ -0,0 +1,106 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"fmt"
+	"image"
+	"io"
+
+	"yrh.dev/icns/internal/codec"
+	"yrh.dev/icns/internal/colorspace"
+)
+
+// ColorSpace describes the color space of an image, as tagged in PNG data.
+// The zero value stands for untagged images, conventionally sRGB.
+type ColorSpace struct {
+	// Name is "sRGB" for images tagged as such, the name of the embedded ICC profile
+	// if any, "calibrated" for images only tagged with gamma or chromaticities, or
+	// empty for untagged images.
+	Name string
+
+	// space is set for the color spaces that can be converted from and to.
+	space  *colorspace.Space
+	chunks []colorspace.Chunk
+}
+
+// Known color spaces, see WithColorConversion.
+var (
+	SRGB      = ColorSpace{Name: colorspace.SRGB.Name, space: colorspace.SRGB, chunks: colorspace.SRGB.Chunks}
+	DisplayP3 = ColorSpace{Name: colorspace.DisplayP3.Name, space: colorspace.DisplayP3, chunks: colorspace.DisplayP3.Chunks}
+)
+
+func newColorSpace(chunks []colorspace.Chunk) ColorSpace {
+	return ColorSpace{Name: colorspace.Name(chunks), chunks: chunks}
+}
+
+// Profile returns the embedded ICC profile, uncompressed, or nil if there is none.
+func (c ColorSpace) Profile() []byte {
+	_, p := colorspace.Profile(c.chunks)
+	return p
+}
+
+// WithColorConversion converts the images passed to Add, Replace, ReplaceSize and
+// FromImages from the src color space to dst, and tags the PNG data written by
+// Encode with dst. Only SRGB and DisplayP3 are supported; these methods fail with
+// ErrUnsupportedColorSpace otherwise. Other encodings don't carry color spaces.
+// Decoded images keep the color space they were tagged with regardless.
+func WithColorConversion(src, dst ColorSpace) Option {
+	return func(i *ICNS) {
+		i.colorSrc, i.colorDst = src, dst
+		i.convertColors = true
+	}
+}
+
+// convert applies the color conversion settings of the icon to an input image.
+func (i *ICNS) convert(im image.Image) (image.Image, error) {
+	if !i.convertColors {
+		return im, nil
+	}
+	if i.colorSrc.space == nil || i.colorDst.space == nil {
+		return nil, fmt.Errorf("%w: %q to %q", ErrUnsupportedColorSpace, i.colorSrc.Name, i.colorDst.Name)
+	}
+	if i.colorSrc.space == i.colorDst.space {
+		return im, nil
+	}
+	return colorspace.Convert(im, i.colorSrc.space, i.colorDst.space), nil
+}
+
+// outputColors returns the color space chunks to tag new images with.
+func (i *ICNS) outputColors() []colorspace.Chunk {
+	if !i.convertColors {
+		return nil
+	}
+	return i.colorDst.chunks
+}
+
+// taggedCodec tags the images it encodes with color space chunks.
+type taggedCodec struct {
+	codec.Codec
+	tags []colorspace.Chunk
+}
+
+func (c taggedCodec) Encode(w io.Writer, im image.Image) error {
+	return c.Codec.Encode(w, &colorspace.Tagged{Image: im, Chunks: c.tags})
+}
+
+// tagged returns a codec writing the provided color space chunks along with PNG
+// data, if any. Only the built-in PNG codecs know how to do that.
+func tagged(c codec.Codec, tags []colorspace.Chunk) codec.Codec {
+	if len(tags) == 0 {
+		return c
+	}
+	return taggedCodec{c, tags}
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestColorSpacePreserved(t *testing.T) {
+	t.Parallel()
+
+	icon, err := Decode(testdataFileReader(t, "mit.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, icon); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for idx, a := range got.Assets() {
+		want := icon.Assets()[idx]
+		if a.ColorSpace.Name != want.ColorSpace.Name {
+			t.Errorf("%s: color space changed by the round trip: got %q, want %q", a.Type, a.ColorSpace.Name, want.ColorSpace.Name)
+		}
+		if a.Type == ic10 && a.ColorSpace.Name != "sRGB" {
+			t.Errorf("%s: unexpected color space %q, want sRGB", a.Type, a.ColorSpace.Name)
+		}
+	}
+}
+
+func TestColorConversion(t *testing.T) {
+	t.Parallel()
+
+	red := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+	draw.Draw(red, red.Bounds(), image.NewUniform(color.NRGBA{0xff, 0, 0, 0xff}), image.Point{}, draw.Src)
+
+	icon := NewICNS(WithMinCompatibility(Lion), WithMaxCompatibility(Lion), WithColorConversion(SRGB, DisplayP3))
+	if err := icon.Add(red); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, icon); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := got.BestMatch(Pixel128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ColorSpace.Name != DisplayP3.Name {
+		t.Errorf("unexpected color space: got %q, want %q", a.ColorSpace.Name, DisplayP3.Name)
+	}
+	if p := a.ColorSpace.Profile(); len(p) < 128 || string(p[36:40]) != "acsp" {
+		t.Errorf("invalid ICC profile: %q", p)
+	}
+
+	// sRGB red is well within the Display P3 gamut.
+	c := color.NRGBAModel.Convert(a.Image().At(0, 0)).(color.NRGBA)
+	if want := (color.NRGBA{0xea, 0x33, 0x23, 0xff}); c != want {
+		t.Errorf("unexpected converted color: got %v, want %v", c, want)
+	}
+
+	icon = NewICNS(WithColorConversion(ColorSpace{Name: "Adobe RGB"}, SRGB))
+	if err := icon.Add(red); !errors.Is(err, ErrUnsupportedColorSpace) {
+		t.Errorf("Add() returned %v, want %v", err, ErrUnsupportedColorSpace)
+	}
+}
@@ -14,43 +14,75 @@
 
 package icns
 
-import "yrh.dev/icns/internal/codec"
+import (
+	"fmt"
+
+	"yrh.dev/icns/internal/codec"
+)
+
+// OSType is the four-character code identifying a chunk in an ICNS file.
+type OSType uint32
 
 const (
-	magic uint32 = ('i'<<24 | 'c'<<16 | 'n'<<8 | 's')
-	is32  uint32 = ('i'<<24 | 's'<<16 | '3'<<8 | '2')
-	s8mk  uint32 = ('s'<<24 | '8'<<16 | 'm'<<8 | 'k')
-	il32  uint32 = ('i'<<24 | 'l'<<16 | '3'<<8 | '2')
-	l8mk  uint32 = ('l'<<24 | '8'<<16 | 'm'<<8 | 'k')
-	ih32  uint32 = ('i'<<24 | 'h'<<16 | '3'<<8 | '2')
-	h8mk  uint32 = ('h'<<24 | '8'<<16 | 'm'<<8 | 'k')
-	it32  uint32 = ('i'<<24 | 't'<<16 | '3'<<8 | '2')
-	t8mk  uint32 = ('t'<<24 | '8'<<16 | 'm'<<8 | 'k')
-	icp4  uint32 = ('i'<<24 | 'c'<<16 | 'p'<<8 | '4')
-	icp5  uint32 = ('i'<<24 | 'c'<<16 | 'p'<<8 | '5')
-	icp6  uint32 = ('i'<<24 | 'c'<<16 | 'p'<<8 | '6')
-	ic04  uint32 = ('i'<<24 | 'c'<<16 | '0'<<8 | '4')
-	ic05  uint32 = ('i'<<24 | 'c'<<16 | '0'<<8 | '5')
-	ic07  uint32 = ('i'<<24 | 'c'<<16 | '0'<<8 | '7')
-	ic08  uint32 = ('i'<<24 | 'c'<<16 | '0'<<8 | '8')
-	ic09  uint32 = ('i'<<24 | 'c'<<16 | '0'<<8 | '9')
-	ic10  uint32 = ('i'<<24 | 'c'<<16 | '1'<<8 | '0')
-	ic11  uint32 = ('i'<<24 | 'c'<<16 | '1'<<8 | '1')
-	ic12  uint32 = ('i'<<24 | 'c'<<16 | '1'<<8 | '2')
-	ic13  uint32 = ('i'<<24 | 'c'<<16 | '1'<<8 | '3')
-	ic14  uint32 = ('i'<<24 | 'c'<<16 | '1'<<8 | '4')
+	magic OSType = ('i'<<24 | 'c'<<16 | 'n'<<8 | 's')
+	toc   OSType = ('T'<<24 | 'O'<<16 | 'C'<<8 | ' ')
+	is32  OSType = ('i'<<24 | 's'<<16 | '3'<<8 | '2')
+	s8mk  OSType = ('s'<<24 | '8'<<16 | 'm'<<8 | 'k')
+	il32  OSType = ('i'<<24 | 'l'<<16 | '3'<<8 | '2')
+	l8mk  OSType = ('l'<<24 | '8'<<16 | 'm'<<8 | 'k')
+	ih32  OSType = ('i'<<24 | 'h'<<16 | '3'<<8 | '2')
+	h8mk  OSType = ('h'<<24 | '8'<<16 | 'm'<<8 | 'k')
+	it32  OSType = ('i'<<24 | 't'<<16 | '3'<<8 | '2')
+	t8mk  OSType = ('t'<<24 | '8'<<16 | 'm'<<8 | 'k')
+	icp4  OSType = ('i'<<24 | 'c'<<16 | 'p'<<8 | '4')
+	icp5  OSType = ('i'<<24 | 'c'<<16 | 'p'<<8 | '5')
+	icp6  OSType = ('i'<<24 | 'c'<<16 | 'p'<<8 | '6')
+	ic04  OSType = ('i'<<24 | 'c'<<16 | '0'<<8 | '4')
+	ic05  OSType = ('i'<<24 | 'c'<<16 | '0'<<8 | '5')
+	ic07  OSType = ('i'<<24 | 'c'<<16 | '0'<<8 | '7')
+	ic08  OSType = ('i'<<24 | 'c'<<16 | '0'<<8 | '8')
+	ic09  OSType = ('i'<<24 | 'c'<<16 | '0'<<8 | '9')
+	ic10  OSType = ('i'<<24 | 'c'<<16 | '1'<<8 | '0')
+	ic11  OSType = ('i'<<24 | 'c'<<16 | '1'<<8 | '1')
+	ic12  OSType = ('i'<<24 | 'c'<<16 | '1'<<8 | '2')
+	ic13  OSType = ('i'<<24 | 'c'<<16 | '1'<<8 | '3')
+	ic14  OSType = ('i'<<24 | 'c'<<16 | '1'<<8 | '4')
 )
 
-func codeRepr(c uint32) string {
+// String returns the four-character representation of the code.
+func (t OSType) String() string {
 	r := []rune{
-		rune(c >> 24 & 0xff),
-		rune(c >> 16 & 0xff),
-		rune(c >> 8 & 0xff),
-		rune(c & 0xff),
+		rune(t >> 24 & 0xff),
+		rune(t >> 16 & 0xff),
+		rune(t >> 8 & 0xff),
+		rune(t & 0xff),
 	}
 	return string(r)
 }
 
+// MarshalText implements encoding.TextMarshaler.
+func (t OSType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *OSType) UnmarshalText(b []byte) error {
+	v, err := ParseOSType(string(b))
+	if err != nil {
+		return err
+	}
+	*t = v
+	return nil
+}
+
+// ParseOSType converts a four-character code such as "ic10" into an OSType.
+func ParseOSType(s string) (OSType, error) {
+	if len(s) != 4 {
+		return 0, fmt.Errorf("%w %q: must be 4 characters long", ErrInvalidOSType, s)
+	}
+	return OSType(s[0])<<24 | OSType(s[1])<<16 | OSType(s[2])<<8 | OSType(s[3]), nil
+}
+
 // Resolution represents the supported resolutions in pixels.
 type Resolution = codec.Resolution
 
@@ -85,3 +117,49 @@ const (
 	// Oldest version
 	Oldest Compatibility = Allegro
 )
+
+var compatNames = []string{"Allegro", "Cheetah", "Leopard", "Lion", "MountainLion"}
+
+// String returns the name of the OS version.
+func (c Compatibility) String() string {
+	if int(c) < len(compatNames) {
+		return compatNames[c]
+	}
+	return fmt.Sprintf("Compatibility(%d)", uint(c))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (c Compatibility) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (c *Compatibility) UnmarshalText(b []byte) error {
+	for idx, n := range compatNames {
+		if n == string(b) {
+			*c = Compatibility(idx)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown compatibility %q", b)
+}
+
+// Encoding represents the way image data is stored in a chunk.
+type Encoding string
+
+// All supported encodings
+const (
+	// EncodingPNG stores the image as a PNG stream.
+	EncodingPNG Encoding = "png"
+	// EncodingJPEG is reported for JPEG data found in existing files. It is not
+	// legal in any slot, since macOS only renders PNG and JPEG 2000 data there.
+	EncodingJPEG Encoding = "jpeg"
+	// EncodingARGB stores the image as RLE-compressed ARGB channels.
+	EncodingARGB Encoding = "argb"
+	// EncodingJPEG2000 stores the image as a JPEG 2000 stream.
+	// Such data can only be attached pre-encoded, see AddEncoded.
+	EncodingJPEG2000 Encoding = "jp2"
+	// EncodingPack stores the image as RLE-compressed RGB channels,
+	// paired with a separate 8-bit mask.
+	EncodingPack Encoding = "pack"
+)
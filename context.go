@@ -0,0 +1,118 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Operation identifies what a Progress report is about.
+type Operation int
+
+// Supported operations.
+const (
+	DecodeOperation Operation = iota
+	EncodeOperation
+)
+
+func (o Operation) String() string {
+	if o == EncodeOperation {
+		return "encode"
+	}
+	return "decode"
+}
+
+// Progress describes a chunk that was just decoded or encoded.
+type Progress struct {
+	Op   Operation
+	Type OSType
+	// Bytes is the size of the chunk data, without its header.
+	Bytes int
+	// Elapsed is the time spent processing that chunk.
+	Elapsed time.Duration
+	// Done is the number of chunks processed so far, out of Total.
+	Done, Total int
+}
+
+// WithProgress sets a function called for each chunk processed by Decode and
+// Encode. Calls never overlap, but they don't follow the file order when chunks are
+// processed in parallel, see WithParallelism.
+func WithProgress(fn func(Progress)) Option {
+	return func(i *ICNS) {
+		i.progress = fn
+	}
+}
+
+// tracker reports the progress of a single operation.
+type tracker struct {
+	mu    sync.Mutex
+	fn    func(Progress)
+	op    Operation
+	done  int
+	total int
+}
+
+func (i *ICNS) tracker(op Operation, total int) *tracker {
+	return &tracker{fn: i.progress, op: op, total: total}
+}
+
+// report records a processed chunk, started at the provided time.
+func (t *tracker) report(code OSType, size int, start time.Time) {
+	if t.fn == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.done++
+	t.fn(Progress{
+		Op:      t.op,
+		Type:    code,
+		Bytes:   size,
+		Elapsed: time.Since(start),
+		Done:    t.done,
+		Total:   t.total,
+	})
+}
+
+// ctxReader fails reads once the context is done, so that codecs stop early.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// ctxWriter fails writes once the context is done, so that codecs stop early.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w ctxWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
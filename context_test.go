@@ -0,0 +1,112 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProgress(t *testing.T) {
+	t.Parallel()
+
+	var reports []Progress
+	record := WithProgress(func(p Progress) {
+		reports = append(reports, p)
+	})
+
+	icon, err := Decode(testdataFileReader(t, "mit.icns"), record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := reports
+
+	reports = nil
+	if err := Encode(new(bytes.Buffer), icon); err != nil {
+		t.Fatal(err)
+	}
+	encoded := reports
+
+	want := []OSType{ic04, ic05, ic07, ic08, ic09, ic10, ic11, ic12, ic13, ic14}
+	for _, tt := range []struct {
+		op      Operation
+		reports []Progress
+	}{
+		{DecodeOperation, decoded},
+		{EncodeOperation, encoded},
+	} {
+		var types []OSType
+		for idx, p := range tt.reports {
+			if p.Op != tt.op || p.Done != idx+1 || p.Total != len(want) || p.Bytes <= 0 {
+				t.Errorf("unexpected %s progress #%d: %+v", tt.op, idx, p)
+			}
+			types = append(types, p.Type)
+		}
+		sort.Slice(types, func(x, y int) bool { return types[x] < types[y] })
+		if diff := cmp.Diff(want, types); diff != "" {
+			t.Errorf("%s progress types mismatch (-want +got):\n%s", tt.op, diff)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	t.Parallel()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := DecodeContext(canceled, testdataFileReader(t, "mit.icns")); !errors.Is(err, context.Canceled) {
+		t.Errorf("DecodeContext() returned %v, want %v", err, context.Canceled)
+	}
+
+	icon, err := Decode(testdataFileReader(t, "mit.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := EncodeContext(canceled, buf, icon); !errors.Is(err, context.Canceled) {
+		t.Errorf("EncodeContext() returned %v, want %v", err, context.Canceled)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("EncodeContext() wrote %d bytes, want none", buf.Len())
+	}
+
+	// cancel while encoding.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var n int
+	icon, err = Decode(testdataFileReader(t, "mit.icns"), WithParallelism(1), WithProgress(func(p Progress) {
+		if p.Op == EncodeOperation {
+			n++
+			cancel()
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EncodeContext(ctx, new(bytes.Buffer), icon); !errors.Is(err, context.Canceled) {
+		t.Errorf("EncodeContext() returned %v, want %v", err, context.Canceled)
+	}
+	if n != 1 {
+		t.Errorf("EncodeContext() went on after cancellation: %d chunks encoded", n)
+	}
+}
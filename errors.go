@@ -0,0 +1,112 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"errors"
+	"fmt"
+
+	"yrh.dev/icns/internal/codec"
+)
+
+// Sentinel errors, to be tested with errors.Is.
+var (
+	// ErrFormat is returned when the data is not a valid ICNS file.
+	ErrFormat = errors.New("invalid ICNS data")
+	// ErrCorrupt is returned when the payload of a chunk is truncated or inconsistent.
+	ErrCorrupt = codec.ErrCorrupt
+	// ErrUnknownEncoding is returned when pre-encoded data can't be identified.
+	ErrUnknownEncoding = codec.ErrUnknownEncoding
+	// ErrNoImage is returned when the icon holds no suitable image.
+	ErrNoImage = errors.New("no image")
+	// ErrNotSquare is returned for images that are not square.
+	ErrNotSquare = errors.New("image is not a square")
+	// ErrUnsupportedSize is returned for images whose size matches no slot.
+	ErrUnsupportedSize = errors.New("unsupported image size")
+	// ErrInvalidOSType is returned when parsing a malformed OSType.
+	ErrInvalidOSType = errors.New("invalid OSType")
+	// ErrUnsupportedType is returned for OSTypes that don't correspond to a supported slot.
+	ErrUnsupportedType = errors.New("unsupported image format")
+	// ErrUnsupportedEncoding is returned for encodings that are not legal for a slot.
+	ErrUnsupportedEncoding = errors.New("unsupported encoding")
+	// ErrIncompatible is returned when a slot or encoding is outside the icon compatibility range.
+	ErrIncompatible = errors.New("not compatible with the requested OS versions")
+	// ErrUnsupportedColorSpace is returned for color conversions that are not supported.
+	ErrUnsupportedColorSpace = errors.New("unsupported color space")
+	// ErrInvalidSpec is returned by Register for incomplete or inconsistent format specs.
+	ErrInvalidSpec = errors.New("invalid format spec")
+	// ErrRegistered is returned by Register for OSTypes that are already supported.
+	ErrRegistered = errors.New("type already registered")
+)
+
+// SizeError reports an image whose size is not acceptable.
+// It matches ErrNotSquare or ErrUnsupportedSize with errors.Is.
+type SizeError struct {
+	Width, Height int
+	// Want is the expected resolution, if a specific one was required.
+	Want Resolution
+}
+
+func (e *SizeError) Error() string {
+	if e.Want != 0 {
+		return fmt.Sprintf("image size %dx%d doesn't match resolution %d", e.Width, e.Height, e.Want)
+	}
+	if e.Width != e.Height {
+		return ErrNotSquare.Error()
+	}
+	return fmt.Sprintf("no available format for resolution %d", e.Width)
+}
+
+// Unwrap returns the matching sentinel error.
+func (e *SizeError) Unwrap() error {
+	if e.Width != e.Height {
+		return ErrNotSquare
+	}
+	return ErrUnsupportedSize
+}
+
+// ChunkError reports a failure to decode or encode a chunk of an ICNS file.
+type ChunkError struct {
+	Type OSType
+	// Offset is the position of the chunk header in the file.
+	Offset int64
+	Err    error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("chunk %s at offset %d: %v", e.Type, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// EncodingError reports an encoding that can't be used for a slot.
+// It wraps either ErrUnsupportedEncoding or ErrIncompatible.
+type EncodingError struct {
+	Type     OSType
+	Encoding Encoding
+	Err      error
+}
+
+func (e *EncodingError) Error() string {
+	return fmt.Sprintf("encoding %s in format %s: %v", e.Encoding, e.Type, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *EncodingError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+	truncated := []byte("icns\x00\x00\x00\x20ic07\x00\x00\x01\x00\x89PNG")
+
+	data := []struct {
+		name string
+		f    func() error
+		want error
+	}{
+		{"bad magic", func() error {
+			_, err := Decode(bytes.NewReader([]byte("nope\x00\x00\x00\x08")))
+			return err
+		}, ErrFormat},
+		{"truncated chunk", func() error {
+			_, err := Decode(bytes.NewReader(truncated))
+			return err
+		}, ErrFormat},
+		{"not square", func() error {
+			return NewICNS().Add(image.NewNRGBA(image.Rect(0, 0, 16, 8)))
+		}, ErrNotSquare},
+		{"unsupported size", func() error {
+			return NewICNS().Add(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+		}, ErrUnsupportedSize},
+		{"no image", func() error {
+			_, err := NewICNS().ByResolution(Pixel16)
+			return err
+		}, ErrNoImage},
+		{"invalid OSType", func() error {
+			_, err := ParseOSType("ic1")
+			return err
+		}, ErrInvalidOSType},
+		{"unsupported type", func() error {
+			return NewICNS().SetEncoding(magic, EncodingPNG)
+		}, ErrUnsupportedType},
+		{"unsupported encoding", func() error {
+			return NewICNS().SetEncoding(is32, EncodingPNG)
+		}, ErrUnsupportedEncoding},
+		{"incompatible encoding", func() error {
+			return NewICNS(WithMaxCompatibility(Cheetah)).SetEncoding(ic04, EncodingPNG)
+		}, ErrIncompatible},
+		{"unknown encoding", func() error {
+			return NewICNS().AddEncoded(ic07, []byte("GIF89a"))
+		}, ErrUnknownEncoding},
+	}
+
+	for _, tt := range data {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if err := tt.f(); !errors.Is(err, tt.want) {
+				t.Errorf("unexpected error: got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	t.Parallel()
+	_, err := Decode(bytes.NewReader([]byte("icns\x00\x00\x00\x20ic07\x00\x00\x01\x00\x89PNG")))
+	var cerr *ChunkError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("unexpected error: got %v, want a *ChunkError", err)
+	}
+	if cerr.Type != ic07 || cerr.Offset != 8 {
+		t.Errorf("unexpected chunk error: got %s at %d, want ic07 at 8", cerr.Type, cerr.Offset)
+	}
+
+	err = NewICNS().Add(image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+	var serr *SizeError
+	if !errors.As(err, &serr) {
+		t.Fatalf("unexpected error: got %v, want a *SizeError", err)
+	}
+	if serr.Width != 100 || serr.Height != 100 {
+		t.Errorf("unexpected size: got %dx%d, want 100x100", serr.Width, serr.Height)
+	}
+
+	err = NewICNS().SetEncoding(is32, EncodingPNG)
+	var eerr *EncodingError
+	if !errors.As(err, &eerr) {
+		t.Fatalf("unexpected error: got %v, want an *EncodingError", err)
+	}
+	if eerr.Type != is32 || eerr.Encoding != EncodingPNG {
+		t.Errorf("unexpected encoding error: got %s in %s, want png in is32", eerr.Encoding, eerr.Type)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"fmt"
+	"image"
+
+	"yrh.dev/icns/internal/resample"
+	"yrh.dev/icns/internal/utils"
+)
+
+// Filter represents the resampling filter used to synthesize images at other sizes.
+// Resampling always happens on alpha-premultiplied, linear light values.
+type Filter uint
+
+// All supported filters
+const (
+	// Lanczos is the sharpest filter, at the cost of some ringing.
+	Lanczos Filter = iota
+	// CatmullRom is a cubic filter, slightly softer than Lanczos but with less ringing.
+	CatmullRom
+)
+
+// WithFilter sets the resampling filter (defaults to Lanczos).
+func WithFilter(f Filter) Option {
+	return func(i *ICNS) {
+		i.filter = f
+	}
+}
+
+// WithSharpening applies an unsharp mask of the provided amount to the images
+// synthesized at the provided resolution. An amount of 1 doubles the local contrast.
+func WithSharpening(r Resolution, amount float64) Option {
+	return func(i *ICNS) {
+		if i.sharpen == nil {
+			i.sharpen = make(map[Resolution]float64)
+		}
+		i.sharpen[r] = amount
+	}
+}
+
+func (i *ICNS) resampleFilter() resample.Filter {
+	if i.filter == CatmullRom {
+		return resample.CatmullRom
+	}
+	return resample.Lanczos
+}
+
+// resize resamples the image to the provided resolution, according to the icon settings.
+// 16-bit images stay 16-bit.
+func (i *ICNS) resize(im image.Image, r Resolution) image.Image {
+	amount := i.sharpen[r]
+	if utils.Is16Bit(im) {
+		res := resample.Resize16(im, int(r), int(r), i.resampleFilter(), true)
+		if amount != 0 {
+			res = resample.Sharpen16(res, amount)
+		}
+		return res
+	}
+
+	res := resample.Resize(im, int(r), int(r), i.resampleFilter(), true)
+	if amount != 0 {
+		res = resample.Sharpen(res, amount)
+	}
+	return res
+}
+
+// Source is an image designed for a given point size and scale.
+type Source struct {
+	Points int
+	// Scale is the display scale factor, 1 if unset.
+	Scale int
+	Image image.Image
+}
+
+func (s Source) scale() int {
+	if s.Scale == 0 {
+		return 1
+	}
+	return s.Scale
+}
+
+func (s Source) res() Resolution {
+	return Resolution(s.Points * s.scale())
+}
+
+// SlotReport describes how an icon slot was filled by FromImages.
+type SlotReport struct {
+	Type OSType
+	// Source is the source image the slot was filled from.
+	Source Source
+	// Synthesized is set if the source image had to be resampled.
+	Synthesized bool
+}
+
+// FromImage creates a new icon based on provided options, and fills every slot
+// allowed by its compatibility range and preset with the master image resampled to the
+// appropriate resolution.
+func FromImage(master image.Image, opts ...Option) (*ICNS, error) {
+	dx := master.Bounds().Dx()
+	i, _, err := FromImages([]Source{{Points: dx, Image: master}}, opts...)
+	return i, err
+}
+
+// FromImages creates a new icon based on provided options, and fills every slot
+// allowed by its compatibility range and preset from the provided sources.
+// A slot is filled with the source designed for its exact size and scale if any,
+// or else a source with the same resolution. Missing slots are synthesized from
+// the nearest larger source, or the largest one if there is none.
+// The returned reports describe how each slot was filled, in the same order as Assets.
+func FromImages(sources []Source, opts ...Option) (*ICNS, []SlotReport, error) {
+	for _, s := range sources {
+		dx := s.Image.Bounds().Dx()
+		dy := s.Image.Bounds().Dy()
+		if dx != dy {
+			return nil, nil, &SizeError{Width: dx, Height: dy}
+		}
+		if Resolution(dx) != s.res() {
+			return nil, nil, &SizeError{Width: dx, Height: dy, Want: s.res()}
+		}
+	}
+
+	i := NewICNS(opts...)
+
+	// convert colors once per source, see WithColorConversion.
+	converted := make(map[*Source]image.Image, len(sources))
+	for idx := range sources {
+		im, err := i.convert(sources[idx].Image)
+		if err != nil {
+			return nil, nil, err
+		}
+		converted[&sources[idx]] = im
+	}
+
+	type key struct {
+		src *Source
+		res Resolution
+	}
+	images := make(map[key]image.Image)
+
+	reports := make(map[*format]SlotReport)
+	for _, f := range sortedImageFormats() {
+		if !i.fills(f) {
+			continue
+		}
+
+		src := pickSource(sources, f)
+		if src == nil {
+			continue
+		}
+
+		synthesized := src.res() != f.res
+		k := key{src, f.res}
+		im, ok := images[k]
+		if !ok {
+			im = converted[src]
+			if synthesized {
+				im = i.resize(im, f.res)
+			}
+			images[k] = im
+		}
+		i.set(f, im)
+		reports[f] = SlotReport{
+			Type:        f.code,
+			Source:      *src,
+			Synthesized: synthesized,
+		}
+	}
+
+	if len(i.assets) == 0 {
+		return nil, nil, fmt.Errorf("no available format: %w", ErrIncompatible)
+	}
+
+	res := make([]SlotReport, 0, len(reports))
+	for _, a := range i.Assets() {
+		res = append(res, reports[a.img.format])
+	}
+	return i, res, nil
+}
+
+// pickSource selects the source to fill the slot for the provided format with.
+func pickSource(sources []Source, f *format) *Source {
+	var best *Source
+	for idx := range sources {
+		s := &sources[idx]
+		if s.res() == f.res && s.scale() == f.scale {
+			return s
+		}
+
+		if best == nil {
+			best = s
+			continue
+		}
+
+		sr, br := s.res(), best.res()
+		switch {
+		case sr == br:
+		case sr >= f.res && br >= f.res:
+			if sr < br {
+				best = s
+			}
+		case sr >= f.res:
+			best = s
+		case br < f.res && sr > br:
+			best = s
+		}
+	}
+	return best
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFromImage(t *testing.T) {
+	t.Parallel()
+	master := testImage(256)
+
+	icon, err := FromImage(master,
+		WithMinCompatibility(Leopard),
+		WithMaxCompatibility(Lion),
+		WithFilter(CatmullRom),
+		WithSharpening(Pixel16, 0.5),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []OSType
+	for _, a := range icon.Assets() {
+		got = append(got, a.Type)
+		if a.Image().Bounds().Dx() != int(a.Resolution) {
+			t.Errorf("%s: unexpected image size %v", a.Type, a.Image().Bounds().Size())
+		}
+		if a.Resolution == Pixel256 && a.Image() != master {
+			t.Errorf("%s: master image should be used as is", a.Type)
+		}
+	}
+
+	want := []OSType{icp4, icp5, icp6, ic07, ic08, ic09, ic10}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("FromImage() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := FromImage(image.NewNRGBA(image.Rect(0, 0, 16, 8))); err == nil {
+		t.Error("FromImage() should reject non-square images")
+	}
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	t.Parallel()
+	master := testImage(128)
+
+	// map iteration order changes from one run to the next.
+	var outputs [][]byte
+	for idx := 0; idx < 5; idx++ {
+		icon, err := FromImage(master, WithMaxCompatibility(Cheetah))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := icon.Add(testImage(32)); err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		if err := Encode(buf, icon); err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, buf.Bytes())
+	}
+	for idx := 1; idx < len(outputs); idx++ {
+		if !bytes.Equal(outputs[0], outputs[idx]) {
+			t.Fatal("Encode() output differs for the same input")
+		}
+	}
+}
+
+func TestFromImages(t *testing.T) {
+	t.Parallel()
+	sources := []Source{
+		{Points: 16, Image: testImage(16)},
+		{Points: 16, Scale: 2, Image: testImage(32)},
+		{Points: 128, Scale: 2, Image: testImage(256)},
+	}
+
+	icon, reports, err := FromImages(sources, WithMinCompatibility(Lion), WithMaxCompatibility(Lion))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type slot struct {
+		Type        OSType
+		Points      int
+		Synthesized bool
+	}
+	var got []slot
+	for idx, a := range icon.Assets() {
+		r := reports[idx]
+		if r.Type != a.Type {
+			t.Errorf("report %d: unexpected type: got %s, want %s", idx, r.Type, a.Type)
+		}
+		if !r.Synthesized && a.Image() != r.Source.Image {
+			t.Errorf("%s: source image should be used as is", a.Type)
+		}
+		got = append(got, slot{r.Type, r.Source.Points, r.Synthesized})
+	}
+
+	want := []slot{
+		{icp4, 16, false},
+		{icp5, 16, false}, // same resolution as 16@2x
+		{icp6, 128, true},
+		{ic07, 128, true},
+		{ic10, 128, true},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("FromImages() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, _, err := FromImages([]Source{{Points: 16, Scale: 2, Image: testImage(16)}}); err == nil {
+		t.Error("FromImages() should reject sources that don't match their size")
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"image"
+	"image/draw"
+
+	"yrh.dev/icns/internal/resample"
+	"yrh.dev/icns/internal/utils"
+)
+
+// SquarePolicy represents the way Add handles non-square images.
+type SquarePolicy uint
+
+// All supported policies
+const (
+	// Reject makes Add fail on non-square images.
+	Reject SquarePolicy = iota
+	// Pad extends the image to a square with transparent borders, see WithAnchor.
+	Pad
+	// Crop keeps the centered square part of the image.
+	Crop
+	// Letterbox scales the image to fit the nearest supported resolution, as
+	// WithAutoResample does, and centers it with transparent borders.
+	Letterbox
+)
+
+// Anchor represents the position of a padded image within its square.
+type Anchor uint
+
+// All supported anchors
+const (
+	Center Anchor = iota
+	Top
+	Bottom
+	Left
+	Right
+	TopLeft
+	TopRight
+	BottomLeft
+	BottomRight
+)
+
+// WithSquarePolicy sets the way Add handles non-square images (defaults to Reject).
+func WithSquarePolicy(p SquarePolicy) Option {
+	return func(i *ICNS) {
+		i.squarePolicy = p
+	}
+}
+
+// WithAnchor sets the position of padded images (defaults to Center).
+func WithAnchor(a Anchor) Option {
+	return func(i *ICNS) {
+		i.anchor = a
+	}
+}
+
+// WithAutoResample makes Add resample images whose resolution has no slot to the
+// nearest supported resolution. See WithFilter for resampling options.
+func WithAutoResample() Option {
+	return func(i *ICNS) {
+		i.autoResample = true
+	}
+}
+
+// offset returns the position of a w*h image inside a square of the provided size.
+func (a Anchor) offset(w, h, size int) image.Point {
+	x, y := (size-w)/2, (size-h)/2
+	switch a {
+	case Top, TopLeft, TopRight:
+		y = 0
+	case Bottom, BottomLeft, BottomRight:
+		y = size - h
+	}
+	switch a {
+	case Left, TopLeft, BottomLeft:
+		x = 0
+	case Right, TopRight, BottomRight:
+		x = size - w
+	}
+	return image.Pt(x, y)
+}
+
+// nearestResolution returns the supported resolution closest to the provided
+// one, within the icon compatibility range. Ties go to the larger resolution.
+func (i *ICNS) nearestResolution(r int) (Resolution, bool) {
+	var best Resolution
+	for _, f := range imageFormats() {
+		if !i.fills(f) {
+			continue
+		}
+
+		d, bd := abs(int(f.res)-r), abs(int(best)-r)
+		if best == 0 || d < bd || (d == bd && f.res > best) {
+			best = f.res
+		}
+	}
+	return best, best != 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// fit applies the icon policies to turn the image into a square of supported resolution.
+func (i *ICNS) fit(im image.Image) (image.Image, error) {
+	b := im.Bounds()
+	dx, dy := b.Dx(), b.Dy()
+
+	size := dx
+	if dy > size {
+		size = dy
+	}
+	if i.squarePolicy == Crop && dx != dy {
+		size = dx + dy - size // smallest side
+	}
+
+	// letterboxing always scales the image, to a supported resolution.
+	target := size
+	if i.autoResample || (i.squarePolicy == Letterbox && dx != dy) {
+		if r, ok := i.nearestResolution(size); ok {
+			target = int(r)
+		}
+	}
+
+	// keep the precision of 16-bit images.
+	canvas := func(size int) draw.Image {
+		if utils.Is16Bit(im) {
+			return image.NewNRGBA64(image.Rect(0, 0, size, size))
+		}
+		return image.NewNRGBA(image.Rect(0, 0, size, size))
+	}
+
+	if dx != dy {
+		switch i.squarePolicy {
+		case Pad:
+			dst := canvas(size)
+			off := i.anchor.offset(dx, dy, size)
+			draw.Draw(dst, image.Rectangle{off, off.Add(b.Size())}, im, b.Min, draw.Src)
+			im = dst
+		case Crop:
+			off := image.Pt((dx-size)/2, (dy-size)/2).Add(b.Min)
+			dst := canvas(size)
+			draw.Draw(dst, dst.Bounds(), im, off, draw.Src)
+			im = dst
+		case Letterbox:
+			// scale the largest side to the target resolution in one go.
+			w, h := dx*target/size, dy*target/size
+			if w == 0 {
+				w = 1
+			}
+			if h == 0 {
+				h = 1
+			}
+			scaled, sp := im, b.Min
+			switch {
+			case w == dx && h == dy:
+				// already at the target resolution.
+			case utils.Is16Bit(im):
+				scaled, sp = resample.Resize16(im, w, h, i.resampleFilter(), true), image.Point{}
+			default:
+				scaled, sp = resample.Resize(im, w, h, i.resampleFilter(), true), image.Point{}
+			}
+			dst := canvas(target)
+			off := Center.offset(w, h, target)
+			draw.Draw(dst, image.Rectangle{off, off.Add(image.Pt(w, h))}, scaled, sp, draw.Src)
+			return dst, nil
+		default:
+			return nil, &SizeError{Width: dx, Height: dy}
+		}
+	}
+
+	if target != size {
+		return i.resize(im, Resolution(target)), nil
+	}
+	return im, nil
+}
@@ -15,8 +15,8 @@
 package icns
 
 import (
+	"context"
 	"image"
-	"image/color"
 	"io"
 	"io/ioutil"
 
@@ -24,31 +24,79 @@ import (
 )
 
 type format struct {
-	code        uint32
-	combineCode uint32
+	code        OSType
+	combineCode OSType
 	res         Resolution
+	scale       int
 	compat      Compatibility
 	codec       codec.Codec
+	// encodings lists the legal encodings for that format, the first one being the default.
+	encodings []Encoding
+	// custom is set for formats added with Register, whose codec handles their encoding.
+	custom bool
+}
+
+// accepts reports whether the format can store data with the provided encoding.
+func (f *format) accepts(e Encoding) bool {
+	for _, fe := range f.encodings {
+		if fe == e {
+			return true
+		}
+	}
+	return false
+}
+
+// codecFor returns the codec writing the provided encoding for that format, or nil.
+func (f *format) codecFor(e Encoding) codec.Codec {
+	// the layout of legacy data depends on the slot.
+	if (f.custom || e == EncodingPack) && f.accepts(e) {
+		return f.codec
+	}
+	return encodingCodecs[e]
+}
+
+// compatFor returns the compatibility of the format when storing data with the provided encoding.
+func (f *format) compatFor(e Encoding) Compatibility {
+	if c := encodingCompat[e]; c > f.compat {
+		return c
+	}
+	return f.compat
 }
 
 var (
-	supportedImageFormats map[uint32]*format
-	supportedMaskFormats  map[uint32]*format
+	supportedImageFormats map[OSType]*format
+	supportedMaskFormats  map[OSType]*format
+
+	encodingCodecs = map[Encoding]codec.Codec{
+		EncodingPNG:  codec.ImageCodec,
+		EncodingARGB: codec.ARGBCodec,
+		EncodingPack: codec.PackCodec,
+	}
+
+	// encodingCompat records the first OS version able to read an encoding.
+	encodingCompat = map[Encoding]Compatibility{
+		EncodingPack:     Allegro,
+		EncodingARGB:     Cheetah,
+		EncodingPNG:      Leopard,
+		EncodingJPEG2000: Leopard,
+	}
 )
 
 func init() {
-	supportedImageFormats = make(map[uint32]*format)
-	supportedMaskFormats = make(map[uint32]*format)
+	supportedImageFormats = make(map[OSType]*format)
+	supportedMaskFormats = make(map[OSType]*format)
 
 	legacyFormats := []struct {
-		code uint32
-		mask uint32
-		res  Resolution
+		code  OSType
+		mask  OSType
+		res   Resolution
+		codec codec.Codec
 	}{
-		{is32, s8mk, Pixel16},
-		{il32, l8mk, Pixel32},
-		{ih32, h8mk, Pixel16},
-		{it32, t8mk, Pixel32},
+		{is32, s8mk, Pixel16, codec.PackCodec},
+		{il32, l8mk, Pixel32, codec.PackCodec},
+		{ih32, h8mk, Pixel48, codec.PackCodec},
+		// it32 data starts with 4 zero bytes.
+		{it32, t8mk, Pixel128, codec.PaddedPackCodec},
 	}
 
 	for _, f := range legacyFormats {
@@ -56,21 +104,24 @@ func init() {
 			code:        f.code,
 			combineCode: f.mask,
 			res:         f.res,
+			scale:       1,
 			compat:      Allegro,
-			codec:       codec.PackCodec,
+			codec:       f.codec,
+			encodings:   []Encoding{EncodingPack},
 		}
 
 		supportedMaskFormats[f.mask] = &format{
 			code:        f.mask,
 			combineCode: f.code,
 			res:         f.res,
+			scale:       1,
 			compat:      Allegro,
 			codec:       codec.MaskCodec,
 		}
 	}
 
 	argbFormats := []struct {
-		code uint32
+		code OSType
 		res  Resolution
 	}{
 		{ic04, Pixel16},
@@ -79,42 +130,52 @@ func init() {
 
 	for _, f := range argbFormats {
 		supportedImageFormats[f.code] = &format{
-			code:   f.code,
-			res:    f.res,
-			compat: Cheetah, // not quite sure
-			codec:  codec.ARGBCodec,
+			code:      f.code,
+			res:       f.res,
+			scale:     1,
+			compat:    Cheetah, // not quite sure
+			codec:     codec.ARGBCodec,
+			encodings: []Encoding{EncodingARGB, EncodingPNG, EncodingJPEG2000},
 		}
 	}
 
+	// the smallest ones can also hold ARGB data, like Apple tools produce.
+	smallEncodings := []Encoding{EncodingPNG, EncodingARGB, EncodingJPEG2000}
+	encodings := []Encoding{EncodingPNG, EncodingJPEG2000}
+
 	modernFormats := []struct {
-		code   uint32
-		res    Resolution
-		compat Compatibility
+		code      OSType
+		res       Resolution
+		scale     int
+		compat    Compatibility
+		encodings []Encoding
 	}{
-		{icp4, Pixel16, Lion},
-		{icp5, Pixel32, Lion},
-		{icp6, Pixel64, Lion},
-		{ic07, Pixel128, Lion},
-		{ic08, Pixel256, Leopard},
-		{ic09, Pixel512, Leopard},
-		{ic10, Pixel1024, Lion},
-		{ic11, Pixel32, MountainLion},
-		{ic12, Pixel64, MountainLion},
-		{ic13, Pixel256, MountainLion},
-		{ic14, Pixel512, MountainLion},
+		{icp4, Pixel16, 1, Lion, smallEncodings},
+		{icp5, Pixel32, 1, Lion, smallEncodings},
+		{icp6, Pixel64, 1, Lion, encodings},
+		{ic07, Pixel128, 1, Lion, encodings},
+		{ic08, Pixel256, 1, Leopard, encodings},
+		{ic09, Pixel512, 1, Leopard, encodings},
+		{ic10, Pixel1024, 2, Lion, encodings},
+		{ic11, Pixel32, 2, MountainLion, encodings},
+		{ic12, Pixel64, 2, MountainLion, encodings},
+		{ic13, Pixel256, 2, MountainLion, encodings},
+		{ic14, Pixel512, 2, MountainLion, encodings},
 	}
 
 	for _, f := range modernFormats {
 		supportedImageFormats[f.code] = &format{
-			code:   f.code,
-			res:    f.res,
-			compat: f.compat,
-			codec:  codec.ImageCodec,
+			code:      f.code,
+			res:       f.res,
+			scale:     f.scale,
+			compat:    f.compat,
+			codec:     codec.ImageCodec,
+			encodings: f.encodings,
 		}
 	}
 
 	// register into image decoding library. Use the highest available resolution for that purpose.
-	image.RegisterFormat("icns", codeRepr(magic),
+	image.RegisterFormat("icns", magic.String(),
 		func(r io.Reader) (image.Image, error) {
 			i, err := Decode(r)
 			if err != nil {
@@ -127,16 +188,17 @@ func init() {
 			if err != nil {
 				return image.Config{}, err
 			}
-			i, err := readICNS(bytes, true)
+			i, err := readICNS(context.Background(), bytes, true, NewICNS())
 			if err != nil {
 				return image.Config{}, err
 			}
-			img, err := i.highestResolutionAsset()
+			// like Decode, skip the images that can't be decoded.
+			img, err := i.highestResolutionAsset((*img).decodable)
 			if err != nil {
 				return image.Config{}, err
 			}
 			return image.Config{
-				ColorModel: color.NRGBAModel,
+				ColorModel: codec.ColorModel(img.raw),
 				Width:      int(img.format.res),
 				Height:     int(img.format.res),
 			}, nil
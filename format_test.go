@@ -16,11 +16,18 @@ package icns
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
 	"io"
 	"io/ioutil"
 	"path"
+	"reflect"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 type test interface {
@@ -72,6 +79,43 @@ func TestDecodeConfig(t *testing.T) {
 	}
 }
 
+func TestHighestResolutionJPEG2000(t *testing.T) {
+	t.Parallel()
+	// minimal JPEG 2000 codestream header for a 1024x1024 image.
+	j2kData := []byte{
+		0xff, 0x4f, 0xff, 0x51, 0x00, 0x29, 0x00, 0x00,
+		0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x04, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	icon := NewICNS()
+	if err := icon.Replace(ic09, testImage(512)); err != nil {
+		t.Fatal(err)
+	}
+	if err := icon.AddEncoded(ic10, j2kData); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, icon); err != nil {
+		t.Fatal(err)
+	}
+
+	// the JPEG 2000 image can't be decoded, the largest other one is used.
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 512 || cfg.Height != 512 {
+		t.Errorf("DecodeConfig() returned size %dx%d, want 512x512", cfg.Width, cfg.Height)
+	}
+	im, _, err := image.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := im.Bounds().Size(); size != image.Pt(512, 512) {
+		t.Errorf("Decode() returned size %v, want 512x512", size)
+	}
+}
+
 func BenchmarkDecodeConfig(b *testing.B) {
 	r := testdataFileReader(b, "mit.icns")
 	b.ResetTimer()
@@ -86,3 +130,194 @@ func BenchmarkDecodeConfig(b *testing.B) {
 		}
 	}
 }
+
+func TestAssets(t *testing.T) {
+	t.Parallel()
+	icon, err := Decode(testdataFileReader(t, "mit.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, a := range icon.Assets() {
+		got = append(got, fmt.Sprintf("%s:%d@%dx:%s", a.Type, a.Points, a.Scale, a.Encoding))
+		if a.Image() == nil || a.Image().Bounds().Dx() != int(a.Resolution) {
+			t.Errorf("%s: image doesn't match resolution %d", a.Type, a.Resolution)
+		}
+	}
+
+	want := []string{
+		"ic04:16@1x:argb",
+		"ic05:32@1x:argb",
+		"ic11:16@2x:png",
+		"ic12:32@2x:png",
+		"ic07:128@1x:png",
+		"ic08:256@1x:png",
+		"ic13:128@2x:png",
+		"ic09:512@1x:png",
+		"ic14:256@2x:png",
+		"ic10:512@2x:png",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Assets() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// chunkData returns the payload of the first chunk of the provided type in an
+// encoded icon, or nil if there is none.
+func chunkData(data []byte, code OSType) []byte {
+	for pos := 8; pos+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[pos+4:]))
+		if size < 8 || pos+size > len(data) {
+			return nil
+		}
+		if OSType(binary.BigEndian.Uint32(data[pos:])) == code {
+			return data[pos+8 : pos+size]
+		}
+		pos += size
+	}
+	return nil
+}
+
+func TestLegacyMasks(t *testing.T) {
+	t.Parallel()
+	// idle.icns comes from Python's IDLE, made with Apple's Icon Composer. The
+	// expected values come from an independent decoder.
+	input, err := ioutil.ReadAll(testdataFileReader(t, "idle.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	icon, err := Decode(bytes.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []struct {
+		code OSType
+		res  Resolution
+		sums [4]int // of the red, green, blue and alpha values
+	}{
+		{is32, Pixel16, [4]int{34299, 34292, 31343, 42905}},
+		{il32, Pixel32, [4]int{137812, 137166, 126165, 172447}},
+		{ih32, Pixel48, [4]int{306069, 305246, 280036, 388304}},
+		{it32, Pixel128, [4]int{3587968, 3594324, 3412022, 4177920}},
+	}
+
+	images := make(map[OSType]*image.NRGBA)
+	for _, a := range icon.Assets() {
+		if im, ok := a.Image().(*image.NRGBA); ok {
+			images[a.Type] = im
+		}
+	}
+	for _, tt := range data {
+		im := images[tt.code]
+		if im == nil {
+			t.Errorf("%s: no NRGBA image decoded", tt.code)
+			continue
+		}
+		if r := im.Bounds(); r.Dx() != int(tt.res) || r.Dy() != int(tt.res) {
+			t.Errorf("%s: got size %v, want %dx%d", tt.code, r.Size(), tt.res, tt.res)
+			continue
+		}
+		var sums [4]int
+		for idx, v := range im.Pix {
+			sums[idx%4] += int(v)
+		}
+		if sums != tt.sums {
+			t.Errorf("%s: got channel sums %v, want %v", tt.code, sums, tt.sums)
+		}
+	}
+
+	// the images must survive a round trip, and masks are stored as is.
+	output := new(bytes.Buffer)
+	if err := Encode(output, icon); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(bytes.NewReader(output.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range decoded.Assets() {
+		want := images[a.Type]
+		if want == nil {
+			continue
+		}
+		got, ok := a.Image().(*image.NRGBA)
+		if !ok || !bytes.Equal(want.Pix, got.Pix) {
+			t.Errorf("%s: pixels changed after a round trip", a.Type)
+		}
+		delete(images, a.Type)
+
+		mask := imageFormats()[a.Type].combineCode
+		if !bytes.Equal(chunkData(output.Bytes(), mask), chunkData(input, mask)) {
+			t.Errorf("%s: mask data changed after a round trip", mask)
+		}
+	}
+	for code := range images {
+		t.Errorf("%s: lost after a round trip", code)
+	}
+	if !bytes.HasPrefix(chunkData(output.Bytes(), it32), make([]byte, 4)) {
+		t.Error("it32: data doesn't start with 4 zero bytes")
+	}
+}
+
+func TestDecodeConfigColorModel(t *testing.T) {
+	t.Parallel()
+	fill := func(f func(x, y int) color.Color) image.Image {
+		var im draw.Image = image.NewNRGBA(image.Rect(0, 0, 128, 128))
+		if _, ok := f(0, 0).(color.NRGBA64); ok {
+			im = image.NewNRGBA64(im.Bounds())
+		}
+		for y := 0; y < 128; y++ {
+			for x := 0; x < 128; x++ {
+				im.Set(x, y, f(x, y))
+			}
+		}
+		return im
+	}
+
+	// the PNG encoder picks the color type from the content.
+	data := []struct {
+		name string
+		img  image.Image
+		want image.Image
+	}{
+		{"gray", fill(func(x, y int) color.Color { return color.NRGBA{uint8(x), uint8(x), uint8(x), 0xff} }), &image.Gray{}},
+		{"gray alpha", fill(func(x, y int) color.Color { return color.NRGBA{uint8(x), uint8(x), uint8(x), uint8(y)} }), &image.NRGBA{}},
+		{"palette", fill(func(x, y int) color.Color { return color.NRGBA{uint8(x / 32), 0, 0, uint8(y / 64)} }), &image.Paletted{}},
+		{"truecolor", fill(func(x, y int) color.Color { return color.NRGBA{uint8(x), uint8(y), 0, 0xff} }), &image.RGBA{}},
+		{"truecolor alpha", fill(func(x, y int) color.Color { return color.NRGBA{uint8(x), uint8(y), 0, 0x80} }), &image.NRGBA{}},
+		{"deep", fill(func(x, y int) color.Color { return color.NRGBA64{uint16(x * 500), uint16(y * 500), 1, 0xffff} }), &image.RGBA64{}},
+		{"deep alpha", fill(func(x, y int) color.Color { return color.NRGBA64{uint16(x * 500), uint16(y * 500), 1, 0x8000} }), &image.NRGBA64{}},
+	}
+
+	for _, tt := range data {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			icon := NewICNS()
+			if err := icon.Replace(ic07, tt.img); err != nil {
+				t.Fatal(err)
+			}
+			buf := new(bytes.Buffer)
+			if err := Encode(buf, icon); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			im, _, err := image.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := fmt.Sprintf("%T", im), fmt.Sprintf("%T", tt.want); got != want {
+				t.Errorf("Decode() returned %s, want %s", got, want)
+			}
+			if !reflect.DeepEqual(cfg.ColorModel, im.ColorModel()) {
+				t.Errorf("DecodeConfig() color model doesn't match the %T returned by Decode()", im)
+			}
+		})
+	}
+}
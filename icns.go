@@ -20,19 +20,134 @@ import (
 	"bytes"
 	"fmt"
 	"image"
+	"sort"
+	"sync"
+
+	"yrh.dev/icns/internal/codec"
+	"yrh.dev/icns/internal/colorspace"
+	"yrh.dev/icns/internal/utils"
 )
 
 type img struct {
 	image.Image
 	format  *format
-	encoder string
+	encoder Encoding
+	// size of the encoded payload (and mask for legacy formats), if known.
+	size, maskSize int
+	// raw holds pre-encoded data, written as is by Encode.
+	raw []byte
+	// color space chunks of the PNG data, written back by Encode.
+	color []colorspace.Chunk
+
+	// mu guards the lazily computed fields below, and the lazy decoding of raw.
+	// Other fields are set before the img is shared, and never change afterwards.
+	mu sync.Mutex
+	// nrgba caches the conversion of the image for the encoders that need one.
+	nrgba *image.NRGBA
+	// undecodable is set once the decoding of raw failed, so that it isn't retried.
+	undecodable bool
+}
+
+// image returns the image data, decoding pre-encoded data if needed.
+// It returns nil if the data can't be decoded.
+func (a *img) image() image.Image {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.Image == nil && a.raw != nil && !a.undecodable {
+		if c := a.format.codecFor(a.encoder); c != nil {
+			a.Image, _, _ = c.Decode(bytes.NewReader(a.raw), a.format.res)
+		}
+		a.undecodable = a.Image == nil
+	}
+	return a.Image
+}
+
+// decodable reports whether the image data can be decoded, without decoding it:
+// JPEG 2000 data can't.
+func (a *img) decodable() bool {
+	if a.decoded() != nil {
+		return true
+	}
+	if a.raw == nil {
+		return false
+	}
+	enc, _, _, err := codec.Sniff(a.raw)
+	return err != nil || enc != string(EncodingJPEG2000)
+}
+
+// decoded returns the image data if it's already available, without decoding anything.
+func (a *img) decoded() image.Image {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.Image
+}
+
+// toNRGBA returns the image data as an NRGBA instance, without altering the
+// stored image. The conversion is only done once.
+func (a *img) toNRGBA() *image.NRGBA {
+	im := a.image()
+	if n, ok := im.(*image.NRGBA); ok {
+		return n
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.nrgba == nil && im != nil {
+		a.nrgba = utils.Img2NRGBA(im)
+	}
+	return a.nrgba
 }
 
 // ICNS encapsulates the Apple Icon Image format specification.
+//
+// An ICNS is safe for concurrent use: the methods that only read the icon (Assets,
+// ByResolution, HighestResolution, BestMatch, Render, RenderSize, Summary, Info,
+// and the Encode function) can run in parallel, while the ones that modify it
+// (Add, AddEncoded, Replace, ReplaceSize, Remove, RemoveResolution, RemoveSize,
+// SetEncoding and SetResolutionEncoding) are serialized with all the others.
+// Options must not be applied to an icon that is already shared.
 type ICNS struct {
+	mu sync.RWMutex
+
 	minCompat, maxCompat Compatibility
-	assets               []*img
-	unsupportedCodes     []uint32
+	// derivedCompat is set when the compatibility range comes from the icon content.
+	derivedCompat bool
+	assets        []*img
+	// chunks that were skipped when decoding.
+	unsupported []*chunk
+	failed      []*chunk
+
+	// encoding policy, see SetEncoding and SetResolutionEncoding.
+	typeEncodings map[OSType]Encoding
+	resEncodings  map[Resolution]Encoding
+	smallest      bool
+	quantization  *Quantization
+
+	// slots to fill and write, along with their order and whether a table of
+	// contents is written, see WithPreset and WithTOC.
+	slots map[OSType]bool
+	order []OSType
+	toc   bool
+
+	// resampling settings, see WithFilter and WithSharpening.
+	filter  Filter
+	sharpen map[Resolution]float64
+
+	// input settings, see WithSquarePolicy, WithAnchor and WithAutoResample.
+	squarePolicy SquarePolicy
+	anchor       Anchor
+	autoResample bool
+
+	// maximum number of representations processed at once, see WithParallelism.
+	parallelism int
+	progress    func(Progress)
+
+	// color conversion of input images, see WithColorConversion.
+	convertColors      bool
+	colorSrc, colorDst ColorSpace
 }
 
 // Option is the type for ICNS creation options.
@@ -52,6 +167,15 @@ func WithMaxCompatibility(c Compatibility) Option {
 	}
 }
 
+// WithSmallestOutput makes Encode try every legal lossless encoding for each image,
+// and keep the smallest one that decodes back to the exact same pixels.
+// Explicit encoding choices are ignored in that mode.
+func WithSmallestOutput() Option {
+	return func(i *ICNS) {
+		i.smallest = true
+	}
+}
+
 // NewICNS creates a new icon based on provided options.
 func NewICNS(opts ...Option) *ICNS {
 	i := &ICNS{
@@ -68,92 +192,392 @@ func NewICNS(opts ...Option) *ICNS {
 
 // ByResolution extracts an image from the icon, at the provided resolution.
 func (i *ICNS) ByResolution(r Resolution) (image.Image, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
 	for _, a := range i.assets {
 		if a.format.res == r {
-			return a.Image, nil
+			if im := a.image(); im != nil {
+				return im, nil
+			}
 		}
 	}
-	return nil, fmt.Errorf("no image by that resolution")
+	return nil, fmt.Errorf("%w by that resolution", ErrNoImage)
 }
 
-func (i *ICNS) highestResolutionAsset() (*img, error) {
-	var res Resolution
-	var img *img
-	for _, a := range i.assets {
-		if a.format.res > res {
-			res = a.format.res
-			img = a
+// highestResolutionAsset returns the first asset with the highest resolution,
+// among the usable ones.
+func (i *ICNS) highestResolutionAsset(usable func(*img) bool) (*img, error) {
+	assets := append([]*img(nil), i.assets...)
+	sort.SliceStable(assets, func(x, y int) bool {
+		return assets[x].format.res > assets[y].format.res
+	})
+	for _, a := range assets {
+		if usable(a) {
+			return a, nil
 		}
 	}
-
-	if img == nil {
-		return nil, fmt.Errorf("no valid image")
-	}
-	return img, nil
+	return nil, fmt.Errorf("%w available", ErrNoImage)
 }
 
 // HighestResolution extracts the image from the icon that has the highest resolution.
 func (i *ICNS) HighestResolution() (image.Image, error) {
-	img, err := i.highestResolutionAsset()
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	// skip the images that can't be decoded, such as JPEG 2000 ones.
+	img, err := i.highestResolutionAsset(func(a *img) bool {
+		return a.image() != nil
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	return img.Image, nil
+	return img.image(), nil
 }
 
 // Add adds new image to the icon, assuming its resolution is acceptable.
 // This also replaces previous images at that resolution.
+// See WithSquarePolicy and WithAutoResample to accept other images.
 func (i *ICNS) Add(im image.Image) error {
+	im, err := i.fit(im)
+	if err != nil {
+		return err
+	}
+	if im, err = i.convert(im); err != nil {
+		return err
+	}
 	dx := im.Bounds().Dx()
-	dy := im.Bounds().Dy()
 
-	if dx != dy {
-		return fmt.Errorf("image is not a square")
-	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
 
 	var supported bool
-	for _, f := range supportedImageFormats {
-		if f.compat < i.minCompat || f.compat > i.maxCompat {
+	for _, f := range sortedImageFormats() {
+		if !i.fills(f) {
 			continue
 		}
 
 		if f.res == Resolution(dx) {
 			supported = true
+			i.set(f, im)
+		}
+	}
+
+	if !supported {
+		return &SizeError{Width: dx, Height: dx}
+	}
+
+	return nil
+}
+
+// set stores the image in the slot for the provided format, replacing any previous
+// image, and returns the new slot content. Previous content is left untouched, as
+// Asset values returned earlier may still refer to it.
+func (i *ICNS) set(f *format, im image.Image) *img {
+	a := &img{
+		Image:  im,
+		format: f,
+		color:  i.outputColors(),
+	}
+
+	for idx, prev := range i.assets {
+		if prev.format == f {
+			i.assets[idx] = a
+			return a
+		}
+	}
+
+	i.assets = append(i.assets, a)
+	return a
+}
+
+// AddEncoded attaches pre-encoded image data to the slot for the provided OSType,
+// replacing any previous image. The data is validated from its header only: it must
+// be PNG or JPEG 2000 data legal for the slot, and its dimensions must match
+// the slot resolution. Like for Replace, the slot must be within the compatibility
+// range of the icon, and its preset if any. Encode writes a copy of the data verbatim.
+func (i *ICNS) AddEncoded(t OSType, data []byte) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	f, ok := imageFormats()[t]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrUnsupportedType, t)
+	}
+	if err := i.checkSlot(f); err != nil {
+		return err
+	}
+
+	enc, w, h, err := codec.Sniff(data)
+	if err != nil {
+		return err
+	}
+	if err := i.checkEncoding(f, Encoding(enc)); err != nil {
+		return err
+	}
+	if w != h || Resolution(w) != f.res {
+		return &SizeError{Width: w, Height: h, Want: f.res}
+	}
+
+	// the caller may reuse the buffer.
+	data = append([]byte(nil), data...)
+
+	a := i.set(f, nil)
+	a.encoder = Encoding(enc)
+	a.size = len(data)
+	a.raw = data
+	a.color = colorspace.Read(data)
+	return nil
+}
+
+// Replace stores the image in the slot for the provided OSType, replacing any
+// previous image. The image must match the slot resolution, and the slot must be
+// within the compatibility range of the icon, and its preset if any.
+func (i *ICNS) Replace(t OSType, im image.Image) error {
+	im, err := i.convert(im)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.replace(t, im)
+}
+
+func (i *ICNS) replace(t OSType, im image.Image) error {
+	f, ok := imageFormats()[t]
+	if !ok {
+		if m, ok := maskFormats()[t]; ok {
+			f = imageFormats()[m.combineCode]
+		} else {
+			return fmt.Errorf("%w %s", ErrUnsupportedType, t)
+		}
+	}
+
+	if err := i.checkSlot(f); err != nil {
+		return err
+	}
+
+	dx := im.Bounds().Dx()
+	dy := im.Bounds().Dy()
+	if dx != dy || Resolution(dx) != f.res {
+		return &SizeError{Width: dx, Height: dy, Want: f.res}
+	}
+
+	i.set(f, im)
+	return nil
+}
+
+// ReplaceSize stores the image in all the slots for the provided point size and
+// scale that are within the compatibility range of the icon, and its preset if any.
+func (i *ICNS) ReplaceSize(points, scale int, im image.Image) error {
+	im, err := i.convert(im)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	slots := i.slotsFor(points, scale)
+	for _, f := range slots {
+		if err := i.replace(f.code, im); err != nil {
+			return err
+		}
+	}
 
-			var found bool
-			for _, a := range i.assets {
-				if a.format == f {
-					found = true
-					a.Image = im
-				}
+	if len(slots) == 0 {
+		return fmt.Errorf("%w: no available format for size %d@%dx", ErrUnsupportedSize, points, scale)
+	}
+	return nil
+}
+
+// slotsFor returns the formats of the slots the icon fills for the provided point
+// size and scale.
+func (i *ICNS) slotsFor(points, scale int) []*format {
+	var res []*format
+	for _, f := range sortedImageFormats() {
+		if int(f.res) == points*scale && f.scale == scale && i.fills(f) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// Remove drops the image stored with the provided OSType, if any, and returns
+// whether something was removed. Legacy images and their masks are removed
+// together, whichever of the two types is provided. Chunks that failed to decode,
+// or are unsupported, are matched on their own type.
+// For decoded icons, the compatibility range is updated to reflect the remaining
+// images. For icons built with NewICNS, the requested range is left untouched.
+func (i *ICNS) Remove(t OSType) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	code := t
+	if m, ok := maskFormats()[t]; ok {
+		code = m.combineCode
+	}
+
+	n := i.removeIf(func(f *format) bool {
+		return f.code == code
+	})
+
+	for _, l := range []*[]*chunk{&i.unsupported, &i.failed} {
+		chunks := (*l)[:0]
+		for _, c := range *l {
+			if c.code == t {
+				n++
+				continue
 			}
+			chunks = append(chunks, c)
+		}
+		*l = chunks
+	}
+
+	return n > 0
+}
+
+// RemoveResolution drops all the images with the provided resolution in pixels,
+// and returns how many were removed. See Remove for the effect on compatibility.
+func (i *ICNS) RemoveResolution(r Resolution) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.removeIf(func(f *format) bool {
+		return f.res == r
+	})
+}
 
-			if !found {
-				i.assets = append(i.assets, &img{
-					Image:  im,
-					format: f,
-				})
+// RemoveSize drops all the images for the provided point size and scale, and
+// returns how many were removed. See Remove for the effect on compatibility.
+func (i *ICNS) RemoveSize(points, scale int) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.removeIf(func(f *format) bool {
+		return int(f.res) == points*scale && f.scale == scale
+	})
+}
+
+func (i *ICNS) removeIf(pred func(*format) bool) int {
+	assets := i.assets[:0]
+	for _, a := range i.assets {
+		if !pred(a.format) {
+			assets = append(assets, a)
+		}
+	}
+	n := len(i.assets) - len(assets)
+	i.assets = assets
+
+	if n > 0 && i.derivedCompat {
+		i.deriveCompat()
+	}
+	return n
+}
+
+// deriveCompat sets the compatibility range to the one covered by the assets, as
+// reported by Asset.Compatibility, or to the full range if there are none.
+func (i *ICNS) deriveCompat() {
+	if len(i.assets) == 0 {
+		i.minCompat, i.maxCompat = Oldest, Newest
+		return
+	}
+	i.minCompat, i.maxCompat = Newest, Oldest
+	for _, a := range i.assets {
+		c := i.asset(a).Compatibility
+		if c < i.minCompat {
+			i.minCompat = c
+		}
+		if c > i.maxCompat {
+			i.maxCompat = c
+		}
+	}
+}
+
+// chunk records a chunk that was skipped when decoding.
+type chunk struct {
+	code OSType
+	size int
+	err  error
+}
+
+// SetEncoding selects the payload encoding used by Encode for the given OSType.
+// It fails if the encoding is not legal for that slot, or requires a more recent
+// OS than the maximum compatibility of the icon.
+func (i *ICNS) SetEncoding(t OSType, e Encoding) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	f, ok := imageFormats()[t]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrUnsupportedType, t)
+	}
+	if err := i.checkEncoding(f, e); err != nil {
+		return err
+	}
+	if f.codecFor(e) == nil {
+		return &EncodingError{Type: t, Encoding: e, Err: fmt.Errorf("%w: can only be attached pre-encoded", ErrUnsupportedEncoding)}
+	}
+
+	if i.typeEncodings == nil {
+		i.typeEncodings = make(map[OSType]Encoding)
+	}
+	i.typeEncodings[t] = e
+	return nil
+}
+
+// SetResolutionEncoding selects the payload encoding used by Encode for all the slots
+// at the given resolution that can legally hold it. Per-OSType choices made with
+// SetEncoding take precedence.
+// It fails if no slot at that resolution accepts the encoding.
+func (i *ICNS) SetResolutionEncoding(r Resolution, e Encoding) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var supported, encodable bool
+	for _, f := range imageFormats() {
+		if f.res == r && i.checkEncoding(f, e) == nil {
+			supported = true
+			if f.codecFor(e) != nil {
+				encodable = true
+				break
 			}
 		}
 	}
 
+	if supported && !encodable {
+		return fmt.Errorf("%w %s: can only be attached pre-encoded", ErrUnsupportedEncoding, e)
+	}
 	if !supported {
-		return fmt.Errorf("no available format for resolution %d", dx)
+		return fmt.Errorf("%w %s: no available format at resolution %d", ErrUnsupportedEncoding, e, r)
 	}
 
+	if i.resEncodings == nil {
+		i.resEncodings = make(map[Resolution]Encoding)
+	}
+	i.resEncodings[r] = e
 	return nil
 }
 
-// Info provides information about the ICNS
-func (i *ICNS) Info() string {
-	buf := new(bytes.Buffer)
-	fmt.Fprintf(buf, "%d images:\n", len(i.assets)+len(i.unsupportedCodes))
-	for _, a := range i.assets {
-		fmt.Fprintf(buf, "[%s] %s image with resolution %d\n", codeRepr(a.format.code), a.encoder, a.Image.Bounds().Dx())
+func (i *ICNS) checkEncoding(f *format, e Encoding) error {
+	if !f.accepts(e) {
+		return &EncodingError{Type: f.code, Encoding: e, Err: ErrUnsupportedEncoding}
+	}
+	if c := f.compatFor(e); c > i.maxCompat {
+		return &EncodingError{Type: f.code, Encoding: e, Err: ErrIncompatible}
+	}
+	return nil
+}
+
+// encodingFor returns the encoding to use for the provided format, according to the icon policy.
+func (i *ICNS) encodingFor(f *format) (Encoding, error) {
+	if e, ok := i.typeEncodings[f.code]; ok {
+		return e, i.checkEncoding(f, e)
 	}
-	for _, c := range i.unsupportedCodes {
-		fmt.Fprintf(buf, "[%s] unsupported image format\n", codeRepr(c))
+	if e, ok := i.resEncodings[f.res]; ok && i.checkEncoding(f, e) == nil {
+		return e, nil
 	}
-	return buf.String()
+	return f.encodings[0], nil
 }
@@ -0,0 +1,552 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"sync"
+	"testing"
+
+	"yrh.dev/icns/internal/utils"
+)
+
+func testImage(size int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(x * 255 / size),
+				G: uint8(y * 255 / size),
+				B: 0x80,
+				A: uint8((x + y) * 255 / (2 * size)),
+			})
+		}
+	}
+	return img
+}
+
+func TestSetEncoding(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		name    string
+		opts    []Option
+		code    string
+		enc     Encoding
+		wantErr bool
+	}{
+		{"argb in icp4", nil, "icp4", EncodingARGB, false},
+		{"jpeg in ic10", nil, "ic10", EncodingJPEG, true},
+		{"png in is32", nil, "is32", EncodingPNG, true},
+		{"argb in ic10", nil, "ic10", EncodingARGB, true},
+		{"png for cheetah", []Option{WithMaxCompatibility(Cheetah)}, "ic04", EncodingPNG, true},
+		{"unknown type", nil, "abcd", EncodingPNG, true},
+	}
+
+	for _, tt := range data {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			code, err := ParseOSType(tt.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = NewICNS(tt.opts...).SetEncoding(code, tt.enc)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SetEncoding(%s, %s) error = %v, wantErr %v", tt.code, tt.enc, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncodingPolicy(t *testing.T) {
+	t.Parallel()
+	icon := NewICNS(WithMinCompatibility(Lion))
+	if err := icon.Add(testImage(32)); err != nil {
+		t.Fatal(err)
+	}
+	if err := icon.SetResolutionEncoding(Pixel32, EncodingARGB); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, icon); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[OSType]Encoding{
+		icp5: EncodingARGB, // accepts ARGB
+		ic11: EncodingPNG,  // doesn't
+	}
+	for _, a := range decoded.assets {
+		if w, ok := want[a.format.code]; ok && a.encoder != w {
+			t.Errorf("unexpected encoding for %s: got %s, want %s", a.format.code, a.encoder, w)
+		}
+		delete(want, a.format.code)
+	}
+	if len(want) != 0 {
+		t.Errorf("missing formats: %v", want)
+	}
+}
+
+func TestSmallestOutput(t *testing.T) {
+	t.Parallel()
+	src := testImage(32)
+
+	var sizes []int
+	for _, opts := range [][]Option{
+		{WithMinCompatibility(Lion)},
+		{WithMinCompatibility(Lion), WithSmallestOutput()},
+	} {
+		icon := NewICNS(opts...)
+		if err := icon.Add(src); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := Encode(buf, icon); err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, buf.Len())
+
+		decoded, err := Decode(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range decoded.assets {
+			if !utils.SamePixels(src, a.Image) {
+				t.Errorf("%s: decoded image differs from source", a.format.code)
+			}
+		}
+	}
+
+	if sizes[1] > sizes[0] {
+		t.Errorf("optimized output is larger: got %d, want at most %d", sizes[1], sizes[0])
+	}
+}
+
+func TestQuantization(t *testing.T) {
+	t.Parallel()
+	src := testImage(128)
+	// add some noise, so that lossless compression doesn't do wonders.
+	seed := uint32(1)
+	for idx := range src.Pix {
+		seed = seed*1664525 + 1013904223
+		src.Pix[idx] ^= uint8(seed>>24) >> 4
+	}
+
+	var sizes []int
+	reports := make(map[OSType]bool)
+	for _, q := range []*Quantization{
+		nil,
+		{MaxError: 0.1},
+		{MaxError: 8, Report: func(c OSType, _ float64, applied bool) { reports[c] = applied }},
+	} {
+		opts := []Option{WithMinCompatibility(Lion), WithMaxCompatibility(Lion)}
+		if q != nil {
+			opts = append(opts, WithQuantization(*q))
+		}
+		icon := NewICNS(opts...)
+		if err := icon.Add(src); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := Encode(buf, icon); err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, buf.Len())
+	}
+
+	if sizes[1] != sizes[0] {
+		t.Errorf("quantization should not apply under a tight threshold: got %d bytes, want %d", sizes[1], sizes[0])
+	}
+	if sizes[2] >= sizes[0] {
+		t.Errorf("quantization should reduce output: got %d bytes, want less than %d", sizes[2], sizes[0])
+	}
+	if applied, ok := reports[ic07]; !ok || !applied {
+		t.Errorf("missing or negative report for ic07: %v", reports)
+	}
+}
+
+func TestSmallestQuantization(t *testing.T) {
+	t.Parallel()
+	src := testImage(32)
+	seed := uint32(1)
+	for idx := range src.Pix {
+		seed = seed*1664525 + 1013904223
+		src.Pix[idx] ^= uint8(seed>>24) >> 4
+	}
+
+	// ic04 and ic05 can only hold ARGB data for Cheetah, which must stay lossless.
+	icon := NewICNS(
+		WithMinCompatibility(Cheetah),
+		WithMaxCompatibility(Cheetah),
+		WithSmallestOutput(),
+		WithQuantization(Quantization{MaxError: 1000}),
+	)
+	if err := icon.Add(src); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, icon); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets := decoded.Assets()
+	if len(assets) == 0 {
+		t.Fatal("no asset decoded")
+	}
+	for _, a := range assets {
+		if a.Encoding != EncodingARGB {
+			t.Errorf("%s: got encoding %s, want %s", a.Type, a.Encoding, EncodingARGB)
+			continue
+		}
+		if a.Resolution == Pixel32 && !utils.SamePixels(src, a.Image()) {
+			t.Errorf("%s: ARGB data was quantized", a.Type)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	t.Parallel()
+	icon, err := Decode(testdataFileReader(t, "mit.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !icon.Remove(ic10) {
+		t.Error("Remove(ic10) = false, want true")
+	}
+	if icon.Remove(ic10) {
+		t.Error("second Remove(ic10) = true, want false")
+	}
+	if n := icon.RemoveSize(16, 2); n != 1 {
+		t.Errorf("RemoveSize(16, 2) = %d, want 1", n)
+	}
+	if n := icon.RemoveResolution(Pixel256); n != 2 {
+		t.Errorf("RemoveResolution(256) = %d, want 2", n)
+	}
+
+	// only the MountainLion formats are left at the top of the range.
+	if icon.maxCompat != MountainLion {
+		t.Errorf("unexpected max compatibility: got %d, want %d", icon.maxCompat, MountainLion)
+	}
+	for _, c := range []OSType{ic12, ic14, ic07} {
+		icon.Remove(c)
+	}
+	if icon.maxCompat != Leopard {
+		t.Errorf("unexpected max compatibility: got %d, want %d", icon.maxCompat, Leopard)
+	}
+
+	if len(icon.Assets()) != 3 {
+		t.Errorf("unexpected number of assets: got %d, want 3", len(icon.Assets()))
+	}
+
+	// the full range is available again once the icon is empty.
+	for _, a := range icon.Assets() {
+		icon.Remove(a.Type)
+	}
+	if icon.minCompat != Oldest || icon.maxCompat != Newest {
+		t.Errorf("unexpected compatibility range: got %d to %d, want %d to %d", icon.minCompat, icon.maxCompat, Oldest, Newest)
+	}
+	if err := icon.Add(testImage(32)); err != nil {
+		t.Errorf("Add() after removing everything: %v", err)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	t.Parallel()
+	icon := NewICNS()
+	if err := icon.Add(testImage(16)); err != nil {
+		t.Fatal(err)
+	}
+	n := len(icon.Assets())
+
+	// the mask type designates the legacy pair.
+	src := testImage(16)
+	if err := icon.Replace(s8mk, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := icon.Replace(ic10, src); err == nil {
+		t.Error("Replace(ic10) with a 16px image should fail")
+	}
+	if err := icon.ReplaceSize(16, 1, src); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(icon.Assets()) != n {
+		t.Errorf("unexpected number of assets: got %d, want %d", len(icon.Assets()), n)
+	}
+	for _, a := range icon.Assets() {
+		if a.Points == 16 && a.Scale == 1 && a.Image() != src {
+			t.Errorf("%s: image was not replaced", a.Type)
+		}
+	}
+
+	if !icon.Remove(s8mk) || icon.Remove(is32) {
+		t.Error("removing the mask should remove the legacy image")
+	}
+}
+
+func TestAddEncoded(t *testing.T) {
+	t.Parallel()
+	pngData := new(bytes.Buffer)
+	if err := png.Encode(pngData, testImage(128)); err != nil {
+		t.Fatal(err)
+	}
+	// minimal JPEG 2000 codestream header for a 512x512 image.
+	j2kData := []byte{
+		0xff, 0x4f, 0xff, 0x51, 0x00, 0x29, 0x00, 0x00,
+		0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x02, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	icon := NewICNS()
+	if err := icon.AddEncoded(ic07, pngData.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := icon.AddEncoded(ic09, j2kData); err != nil {
+		t.Fatal(err)
+	}
+	if err := icon.AddEncoded(ic08, pngData.Bytes()); err == nil {
+		t.Error("AddEncoded(ic08) with a 128px image should fail")
+	}
+	if err := icon.AddEncoded(it32, pngData.Bytes()); err == nil {
+		t.Error("AddEncoded(it32) should fail for legacy formats")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, icon); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{pngData.Bytes(), j2kData} {
+		if !bytes.Contains(buf.Bytes(), data) {
+			t.Error("pre-encoded data was not written verbatim")
+		}
+	}
+
+	for _, a := range icon.Assets() {
+		switch a.Type {
+		case ic07:
+			if !utils.SamePixels(testImage(128), a.Image()) {
+				t.Error("ic07: decoded image differs from source")
+			}
+		case ic09:
+			if a.Encoding != EncodingJPEG2000 || a.Image() != nil {
+				t.Errorf("ic09: unexpected %s data", a.Encoding)
+			}
+		}
+	}
+
+	// JPEG 2000 data can't be decoded, but must survive a round trip.
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(decoded.failed); n != 0 {
+		t.Errorf("decoded icon has %d failed chunks, want none", n)
+	}
+	out := new(bytes.Buffer)
+	if err := Encode(out, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out.Bytes(), j2kData) {
+		t.Error("JPEG 2000 data was lost after a round trip")
+	}
+
+	if err := NewICNS(WithMaxCompatibility(Leopard)).AddEncoded(ic07, pngData.Bytes()); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("AddEncoded(ic07) for Leopard error = %v, want %v", err, ErrIncompatible)
+	}
+
+	// the data is copied, the caller can reuse its buffer.
+	reused := append([]byte(nil), pngData.Bytes()...)
+	icon = NewICNS()
+	if err := icon.AddEncoded(ic07, reused); err != nil {
+		t.Fatal(err)
+	}
+	copy(reused, make([]byte, len(reused)))
+	out.Reset()
+	if err := Encode(out, icon); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out.Bytes(), pngData.Bytes()) {
+		t.Error("pre-encoded data changed with the caller buffer")
+	}
+}
+
+func TestAddPolicies(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		name        string
+		opts        []Option
+		w, h        int
+		want        Resolution
+		transparent image.Point // a pixel expected to be transparent
+		wantErr     bool
+	}{
+		{"reject", nil, 32, 16, 0, image.Point{}, true},
+		{"off-size", nil, 100, 100, 0, image.Point{}, true},
+		{"pad", []Option{WithSquarePolicy(Pad)}, 32, 16, Pixel32, image.Pt(16, 2), false},
+		{"pad anchored", []Option{WithSquarePolicy(Pad), WithAnchor(Bottom)}, 32, 16, Pixel32, image.Pt(16, 2), false},
+		{"crop", []Option{WithSquarePolicy(Crop)}, 48, 32, Pixel32, image.Pt(-1, -1), false},
+		{"letterbox", []Option{WithSquarePolicy(Letterbox), WithAutoResample()}, 60, 30, Pixel64, image.Pt(32, 4), false},
+		{"letterbox without auto-resample", []Option{WithSquarePolicy(Letterbox)}, 100, 50, Pixel128, image.Pt(64, 4), false},
+		{"letterbox at slot size", []Option{WithSquarePolicy(Letterbox)}, 32, 16, Pixel32, image.Pt(16, 2), false},
+		{"resample", []Option{WithAutoResample()}, 100, 100, Pixel128, image.Pt(-1, -1), false},
+	}
+
+	for _, tt := range data {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			src := image.NewNRGBA(image.Rect(0, 0, tt.w, tt.h))
+			for idx := range src.Pix {
+				src.Pix[idx] = 0xff
+			}
+
+			icon := NewICNS(tt.opts...)
+			err := icon.Add(src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Add() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			im, err := icon.ByResolution(tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nrgba := utils.Img2NRGBA(im)
+			if p := tt.transparent; p.In(nrgba.Rect) && nrgba.NRGBAAt(p.X, p.Y).A != 0 {
+				t.Errorf("pixel at %v should be transparent", p)
+			}
+			if c := nrgba.NRGBAAt(int(tt.want)/2, int(tt.want)/2); c.A != 0xff {
+				t.Errorf("center pixel should be opaque, got %v", c)
+			}
+		})
+	}
+}
+
+func TestEncodeConcurrent(t *testing.T) {
+	t.Parallel()
+
+	// a premultiplied image, which the legacy encoders can't use as is.
+	im := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	draw.Draw(im, im.Bounds(), testImage(32), image.Point{}, draw.Src)
+
+	icon := NewICNS()
+	if err := icon.Add(im); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 4
+	outputs := make([][]byte, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for idx := 0; idx < n; idx++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			buf := new(bytes.Buffer)
+			errs[idx] = Encode(buf, icon)
+			outputs[idx] = buf.Bytes()
+		}(idx)
+	}
+	wg.Wait()
+
+	for idx := 0; idx < n; idx++ {
+		if errs[idx] != nil {
+			t.Fatalf("Encode() #%d failed: %v", idx, errs[idx])
+		}
+		if !bytes.Equal(outputs[idx], outputs[0]) {
+			t.Errorf("Encode() #%d output differs from #0", idx)
+		}
+	}
+
+	for _, a := range icon.Assets() {
+		if got, ok := a.Image().(*image.RGBA); !ok || got != im {
+			t.Errorf("%s: image replaced by Encode, got %T", a.Type, a.Image())
+		}
+	}
+}
+
+func TestDeepColor(t *testing.T) {
+	t.Parallel()
+
+	// a gradient using the full 16-bit range, so that any truncation shows.
+	deep := func(size int) *image.NRGBA64 {
+		im := image.NewNRGBA64(image.Rect(0, 0, size, size))
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				v := uint16((y*size + x) * 0xffff / (size*size - 1))
+				im.SetNRGBA64(x, y, color.NRGBA64{v, 0xffff - v, v ^ 0x5a5a, 0x8000 | v>>1})
+			}
+		}
+		return im
+	}
+
+	src := deep(256)
+	icon, _, err := FromImages([]Source{{Points: 256, Image: src}}, WithMinCompatibility(Leopard), WithMaxCompatibility(Lion))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, icon); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ColorModel != color.NRGBA64Model {
+		t.Errorf("DecodeConfig() returned color model %v, want NRGBA64", cfg.ColorModel)
+	}
+
+	got, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range got.Assets() {
+		if a.Encoding != EncodingPNG {
+			// the legacy formats are 8-bit only.
+			continue
+		}
+		im, ok := a.Image().(*image.NRGBA64)
+		if !ok {
+			t.Errorf("%s: decoded to %T, want *image.NRGBA64", a.Type, a.Image())
+			continue
+		}
+		if a.Type == ic08 && !bytes.Equal(im.Pix, src.Pix) {
+			t.Errorf("%s: 16-bit values changed by the round trip", a.Type)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"yrh.dev/icns/internal/codec"
+	"yrh.dev/icns/internal/colorspace"
+)
+
+// IconsetReport describes how the files of an .iconset directory, or an asset
+// catalog icon set, were used.
+type IconsetReport struct {
+	// Missing lists the names of the standard .iconset files that weren't found,
+	// for the sizes the icon has slots for.
+	Missing []string
+	// Unexpected lists the files that don't follow the naming scheme, or that
+	// the icon has no slot for.
+	Unexpected []string
+}
+
+// iconsetName matches the names of the images in an .iconset directory.
+var iconsetName = regexp.MustCompile(`^icon_(\d+)x(\d+)(@2x)?\.png$`)
+
+// iconsetPoints are the point sizes of a complete .iconset, at 1x and 2x.
+var iconsetPoints = []int{16, 32, 128, 256, 512}
+
+// iconsetFile returns the name of the image for the provided size and scale.
+func iconsetFile(points, scale int) string {
+	if scale == 2 {
+		return fmt.Sprintf("icon_%dx%d@2x.png", points, points)
+	}
+	return fmt.Sprintf("icon_%dx%d.png", points, points)
+}
+
+// iconsetDir lists and reads the files of an .iconset directory.
+type iconsetDir interface {
+	names() ([]string, error)
+	read(name string) ([]byte, error)
+}
+
+// osDir is an .iconset directory on the local filesystem.
+type osDir string
+
+func (d osDir) names() ([]string, error) {
+	infos, err := ioutil.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, info := range infos {
+		if !info.IsDir() {
+			res = append(res, info.Name())
+		}
+	}
+	return res, nil
+}
+
+func (d osDir) read(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(string(d), name))
+}
+
+func (d osDir) WriteFile(name string, data []byte) error {
+	return ioutil.WriteFile(filepath.Join(string(d), name), data, 0o644)
+}
+
+// ReadIconset creates a new icon based on provided options, from the PNG images of
+// an .iconset directory, named icon_<W>x<H>[@2x].png as iconutil expects.
+// Each image is stored in all the slots for its size and scale, within the
+// compatibility range and preset of the icon, and must match the size in its
+// name. Missing images are not synthesized. Hidden files are ignored.
+func ReadIconset(dir string, opts ...Option) (*ICNS, *IconsetReport, error) {
+	return readIconset(osDir(dir), opts)
+}
+
+func readIconset(dir iconsetDir, opts []Option) (*ICNS, *IconsetReport, error) {
+	names, err := dir.names()
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(names)
+
+	i := NewICNS(opts...)
+	report := &IconsetReport{}
+	found := make(map[string]bool)
+	for _, name := range names {
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		m := iconsetName.FindStringSubmatch(name)
+		if m == nil || m[1] != m[2] {
+			report.Unexpected = append(report.Unexpected, name)
+			continue
+		}
+		points, err := strconv.Atoi(m[1])
+		if err != nil {
+			report.Unexpected = append(report.Unexpected, name)
+			continue
+		}
+		scale := 1
+		if m[3] != "" {
+			scale = 2
+		}
+		slots := i.slotsFor(points, scale)
+		if len(slots) == 0 {
+			report.Unexpected = append(report.Unexpected, name)
+			continue
+		}
+
+		if err := i.load(dir, name, slots); err != nil {
+			return nil, nil, err
+		}
+		found[name] = true
+	}
+
+	report.Missing = i.missing(found)
+	if len(i.assets) == 0 {
+		return nil, report, fmt.Errorf("%w in iconset", ErrNoImage)
+	}
+	return i, report, nil
+}
+
+// load stores the PNG image of an icon set file in the provided slots, all for
+// the same resolution.
+func (i *ICNS) load(dir iconsetDir, name string, slots []*format) error {
+	data, err := dir.read(name)
+	if err != nil {
+		return err
+	}
+	im, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	r := slots[0].res
+	if b := im.Bounds(); b.Dx() != int(r) || b.Dy() != int(r) {
+		return fmt.Errorf("%s: %w", name, &SizeError{Width: b.Dx(), Height: b.Dy(), Want: r})
+	}
+	if im, err = i.convert(im); err != nil {
+		return err
+	}
+
+	// keep the color space of the file, unless converted.
+	tags := colorspace.Read(data)
+	for _, f := range slots {
+		if a := i.set(f, im); !i.convertColors {
+			a.color = tags
+		}
+	}
+	return nil
+}
+
+// missing lists the names of the standard .iconset files that weren't found,
+// for the sizes the icon has slots for.
+func (i *ICNS) missing(found map[string]bool) []string {
+	var res []string
+	for _, points := range iconsetPoints {
+		for _, scale := range []int{1, 2} {
+			name := iconsetFile(points, scale)
+			if !found[name] && len(i.slotsFor(points, scale)) != 0 {
+				res = append(res, name)
+			}
+		}
+	}
+	return res
+}
+
+// WritableFS is a filesystem WriteIconsetFS can create files in.
+type WritableFS interface {
+	// WriteFile creates or replaces the named file with the provided data.
+	WriteFile(name string, data []byte) error
+}
+
+// IconsetFile describes an image written by WriteIconset.
+type IconsetFile struct {
+	Name string
+	// Type is the chunk the image comes from.
+	Type OSType
+	// Synthesized is set if the image had to be resampled.
+	Synthesized bool
+}
+
+// IconsetOption is the type for WriteIconset options.
+type IconsetOption func(*iconsetOptions)
+
+type iconsetOptions struct {
+	scales     []int
+	synthesize bool
+}
+
+// WithIconsetScales restricts the images written to the provided scale factors,
+// 1 and 2 by default.
+func WithIconsetScales(scales ...int) IconsetOption {
+	return func(o *iconsetOptions) {
+		o.scales = scales
+	}
+}
+
+// WithIconsetSynthesis makes WriteIconset synthesize the images the icon doesn't
+// hold, from the nearest larger one, or the largest one if there is none. They are
+// resampled according to the icon settings, see WithFilter and WithSharpening.
+func WithIconsetSynthesis() IconsetOption {
+	return func(o *iconsetOptions) {
+		o.synthesize = true
+	}
+}
+
+// WriteIconset writes the images of the icon as PNG files into the provided
+// directory, creating it if needed, and named like iconutil does: icon_16x16.png
+// to icon_512x512@2x.png. Other sizes are skipped.
+// An image is written from the representation for its exact size and scale if any,
+// or else one with the same resolution, preferring the most recent formats.
+// PNG data attached with AddEncoded is written verbatim. The returned list
+// describes the files written, in order.
+func WriteIconset(dir string, i *ICNS, opts ...IconsetOption) ([]IconsetFile, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return WriteIconsetFS(osDir(dir), i, opts...)
+}
+
+// WriteIconsetFS is like WriteIconset, but creates the files at the root of fsys.
+func WriteIconsetFS(fsys WritableFS, i *ICNS, opts ...IconsetOption) ([]IconsetFile, error) {
+	o := &iconsetOptions{scales: []int{1, 2}}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	// one source per resolution and scale, from the most recent format.
+	type key struct {
+		res   Resolution
+		scale int
+	}
+	best := make(map[key]*img)
+	var keys []key
+	for _, a := range i.assets {
+		if a.image() == nil {
+			continue
+		}
+		k := key{a.format.res, a.format.scale}
+		if b, ok := best[k]; !ok {
+			keys = append(keys, k)
+		} else if b.format.compat >= a.format.compat {
+			continue
+		}
+		best[k] = a
+	}
+	sort.Slice(keys, func(x, y int) bool {
+		if keys[x].res != keys[y].res {
+			return keys[x].res < keys[y].res
+		}
+		return keys[x].scale < keys[y].scale
+	})
+	sources := make([]Source, len(keys))
+	for idx, k := range keys {
+		sources[idx] = Source{Points: int(k.res) / k.scale, Scale: k.scale, Image: best[k].image()}
+	}
+
+	var res []IconsetFile
+	for _, points := range iconsetPoints {
+		for _, scale := range o.scales {
+			f := &format{res: Resolution(points * scale), scale: scale}
+			src := pickSource(sources, f)
+			if src == nil {
+				continue
+			}
+			a := best[key{src.res(), src.scale()}]
+			synthesized := src.res() != f.res
+			if synthesized && !o.synthesize {
+				continue
+			}
+
+			var data []byte
+			if enc, _, _, err := codec.Sniff(a.raw); err == nil && enc == string(EncodingPNG) && !synthesized {
+				data = a.raw
+			} else {
+				im := src.Image
+				if synthesized {
+					im = i.resize(im, f.res)
+				}
+				// favor speed, the files are meant to be edited.
+				buf := new(bytes.Buffer)
+				if err := tagged(codec.PNGCodec(png.DefaultCompression), a.color).Encode(buf, im); err != nil {
+					return res, err
+				}
+				data = buf.Bytes()
+			}
+
+			name := iconsetFile(points, scale)
+			if err := fsys.WriteFile(name, data); err != nil {
+				return res, err
+			}
+			res = append(res, IconsetFile{Name: name, Type: a.format.code, Synthesized: synthesized})
+		}
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("%w to write", ErrNoImage)
+	}
+	return res, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.16
+// +build go1.16
+
+package icns
+
+import (
+	"io/fs"
+	"path"
+)
+
+// fsDir is an .iconset directory in a fs.FS.
+type fsDir struct {
+	fsys fs.FS
+	dir  string
+}
+
+func (d fsDir) names() ([]string, error) {
+	entries, err := fs.ReadDir(d.fsys, d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			res = append(res, e.Name())
+		}
+	}
+	return res, nil
+}
+
+func (d fsDir) read(name string) ([]byte, error) {
+	return fs.ReadFile(d.fsys, path.Join(d.dir, name))
+}
+
+// ReadIconsetFS is like ReadIconset, but reads the .iconset directory at the
+// provided path of fsys.
+func ReadIconsetFS(fsys fs.FS, dir string, opts ...Option) (*ICNS, *IconsetReport, error) {
+	return readIconset(fsDir{fsys, dir}, opts)
+}
+
+// ReadAppIconsetFS is like ReadAppIconset, but reads the icon set directory at
+// the provided path of fsys.
+func ReadAppIconsetFS(fsys fs.FS, dir string, opts ...Option) (*ICNS, *IconsetReport, error) {
+	return readAppIconset(fsDir{fsys, dir}, opts)
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.16
+// +build go1.16
+
+package icns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadIconsetFS(t *testing.T) {
+	t.Parallel()
+
+	icon, report, err := ReadIconsetFS(os.DirFS("testdata"), "mit.iconset", WithPreset(ModernAppPreset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&IconsetReport{}, report); diff != "" {
+		t.Errorf("ReadIconsetFS() report mismatch (-want +got):\n%s", diff)
+	}
+	if n := len(icon.Assets()); n != 10 {
+		t.Errorf("unexpected number of assets: got %d, want 10", n)
+	}
+
+	fsys := fstest.MapFS{
+		"app/icon.iconset/icon_512x512@2x.png": {Data: mustReadFile(t, filepath.Join("testdata", "mit.iconset", "icon_512x512@2x.png"))},
+		"app/icon.iconset/README":              {Data: []byte("hello")},
+	}
+	icon, report, err = ReadIconsetFS(fsys, "app/icon.iconset", WithPreset(MinimalPreset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &IconsetReport{
+		Missing:    []string{"icon_128x128.png", "icon_256x256.png", "icon_512x512.png"},
+		Unexpected: []string{"README"},
+	}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("ReadIconsetFS() report mismatch (-want +got):\n%s", diff)
+	}
+	if a := icon.Assets(); len(a) != 1 || a[0].Type != ic10 {
+		t.Errorf("unexpected assets: got %d, want ic10 only", len(a))
+	}
+}
+
+func TestReadAppIconsetFS(t *testing.T) {
+	t.Parallel()
+
+	icon, err := Decode(testdataFileReader(t, "mit.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := memFS{}
+	if _, err := WriteAppIconsetFS(files, icon, WithIconsetScales(1)); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{}
+	for name, data := range files {
+		fsys["Assets.xcassets/AppIcon.appiconset/"+name] = &fstest.MapFile{Data: data}
+	}
+
+	got, report, err := ReadAppIconsetFS(fsys, "Assets.xcassets/AppIcon.appiconset", WithPreset(ModernAppPreset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &IconsetReport{
+		Missing: []string{"icon_16x16@2x.png", "icon_32x32@2x.png", "icon_128x128@2x.png", "icon_256x256@2x.png", "icon_512x512@2x.png"},
+	}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("ReadAppIconsetFS() report mismatch (-want +got):\n%s", diff)
+	}
+	if n := len(got.Assets()); n != 5 {
+		t.Errorf("unexpected number of assets: got %d, want 5", n)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"yrh.dev/icns/internal/utils"
+)
+
+func TestReadIconset(t *testing.T) {
+	t.Parallel()
+
+	icon, report, err := ReadIconset(filepath.Join("testdata", "mit.iconset"), WithPreset(IconutilPreset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&IconsetReport{}, report); diff != "" {
+		t.Errorf("ReadIconset() report mismatch (-want +got):\n%s", diff)
+	}
+
+	// iconutil built mit.icns from the same images.
+	want, err := Decode(testdataFileReader(t, "mit.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := icon.Assets()
+	if len(got) != len(want.Assets()) {
+		t.Fatalf("unexpected number of assets: got %d, want %d", len(got), len(want.Assets()))
+	}
+	for idx, w := range want.Assets() {
+		a := got[idx]
+		if a.Type != w.Type {
+			t.Errorf("asset #%d: got %s, want %s", idx, a.Type, w.Type)
+		}
+		if a.Image().Bounds() != w.Image().Bounds() {
+			t.Errorf("%s: unexpected bounds %v", a.Type, a.Image().Bounds())
+		}
+	}
+}
+
+func TestReadIconsetReport(t *testing.T) {
+	t.Parallel()
+
+	src := filepath.Join("testdata", "mit.iconset")
+	dir, err := ioutil.TempDir("", "icns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, orig := range map[string]string{
+		"icon_32x32.png":    "icon_32x32.png",
+		"icon_16x16@2x.png": "icon_16x16@2x.png",
+		"icon_128x128.png":  "icon_128x128.png",
+		"icon_24x24.png":    "icon_128x128.png",
+		"notes.txt":         "icon_16x16.png",
+		"icon_16x32.png":    "icon_16x16.png",
+		".DS_Store":         "icon_16x16.png",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), mustReadFile(t, filepath.Join(src, orig)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	icon, report, err := ReadIconset(dir, WithPreset(ModernAppPreset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &IconsetReport{
+		Missing: []string{
+			"icon_16x16.png",
+			"icon_32x32@2x.png",
+			"icon_128x128@2x.png",
+			"icon_256x256.png",
+			"icon_256x256@2x.png",
+			"icon_512x512.png",
+			"icon_512x512@2x.png",
+		},
+		Unexpected: []string{"icon_16x32.png", "icon_24x24.png", "notes.txt"},
+	}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("ReadIconset() report mismatch (-want +got):\n%s", diff)
+	}
+
+	var types []OSType
+	for _, a := range icon.Assets() {
+		types = append(types, a.Type)
+	}
+	if diff := cmp.Diff([]OSType{icp5, ic11, ic07}, types); diff != "" {
+		t.Errorf("unexpected slots (-want +got):\n%s", diff)
+	}
+
+	// an image that doesn't match its name.
+	if err := ioutil.WriteFile(filepath.Join(dir, "icon_256x256.png"), mustReadFile(t, filepath.Join(src, "icon_128x128.png")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var serr *SizeError
+	if _, _, err := ReadIconset(dir); !errors.As(err, &serr) || serr.Want != Pixel256 {
+		t.Errorf("ReadIconset() returned %v, want a size error", err)
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.Mkdir(empty, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReadIconset(empty); !errors.Is(err, ErrNoImage) {
+		t.Errorf("ReadIconset() of an empty directory returned %v, want %v", err, ErrNoImage)
+	}
+}
+
+func mustReadFile(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// memFS is an in-memory WritableFS.
+type memFS map[string][]byte
+
+func (m memFS) WriteFile(name string, data []byte) error {
+	m[name] = data
+	return nil
+}
+
+func TestWriteIconset(t *testing.T) {
+	t.Parallel()
+
+	icon, err := Decode(testdataFileReader(t, "mit.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir("", "icns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "mit.iconset")
+	files, err := WriteIconset(dir, icon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []IconsetFile{
+		{Name: "icon_16x16.png", Type: ic04},
+		{Name: "icon_16x16@2x.png", Type: ic11},
+		{Name: "icon_32x32.png", Type: ic05},
+		{Name: "icon_32x32@2x.png", Type: ic12},
+		{Name: "icon_128x128.png", Type: ic07},
+		{Name: "icon_128x128@2x.png", Type: ic13},
+		{Name: "icon_256x256.png", Type: ic08},
+		{Name: "icon_256x256@2x.png", Type: ic14},
+		{Name: "icon_512x512.png", Type: ic09},
+		{Name: "icon_512x512@2x.png", Type: ic10},
+	}
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Errorf("WriteIconset() mismatch (-want +got):\n%s", diff)
+	}
+
+	// the images survive the round trip.
+	got, report, err := ReadIconset(dir, WithPreset(IconutilPreset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&IconsetReport{}, report); diff != "" {
+		t.Errorf("ReadIconset() report mismatch (-want +got):\n%s", diff)
+	}
+	for idx, a := range got.Assets() {
+		w := icon.Assets()[idx]
+		if a.Type != w.Type || a.ColorSpace.Name != w.ColorSpace.Name {
+			t.Errorf("asset #%d: got %s in %q, want %s in %q", idx, a.Type, a.ColorSpace.Name, w.Type, w.ColorSpace.Name)
+			continue
+		}
+		if !bytes.Equal(utils.Img2NRGBA(w.Image()).Pix, utils.Img2NRGBA(a.Image()).Pix) {
+			t.Errorf("%s: pixels changed by the round trip", a.Type)
+		}
+	}
+}
+
+func TestWriteIconsetSynthesis(t *testing.T) {
+	t.Parallel()
+
+	icon := NewICNS()
+	if err := icon.Add(testImage(1024)); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := memFS{}
+	files, err := WriteIconsetFS(fsys, icon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]IconsetFile{{Name: "icon_512x512@2x.png", Type: ic10}}, files); diff != "" {
+		t.Errorf("WriteIconsetFS() mismatch (-want +got):\n%s", diff)
+	}
+
+	fsys = memFS{}
+	files, err = WriteIconsetFS(fsys, icon, WithIconsetScales(1), WithIconsetSynthesis())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		if !f.Synthesized || f.Type != ic10 {
+			t.Errorf("%s: unexpected source %s, synthesized: %v", f.Name, f.Type, f.Synthesized)
+		}
+		names = append(names, f.Name)
+	}
+	wantNames := []string{"icon_16x16.png", "icon_32x32.png", "icon_128x128.png", "icon_256x256.png", "icon_512x512.png"}
+	if diff := cmp.Diff(wantNames, names); diff != "" {
+		t.Errorf("WriteIconsetFS() mismatch (-want +got):\n%s", diff)
+	}
+	if len(fsys) != len(wantNames) {
+		t.Errorf("unexpected number of files written: got %d, want %d", len(fsys), len(wantNames))
+	}
+
+	if _, err := WriteIconsetFS(memFS{}, NewICNS()); !errors.Is(err, ErrNoImage) {
+		t.Errorf("WriteIconsetFS() of an empty icon returned %v, want %v", err, ErrNoImage)
+	}
+}
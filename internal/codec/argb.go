@@ -15,6 +15,7 @@
 package codec
 
 import (
+	"bytes"
 	"image"
 	"io"
 	"io/ioutil"
@@ -51,9 +52,17 @@ func (c *argbCodec) Decode(r io.Reader, res Resolution) (image.Image, string, er
 		return nil, "", err
 	}
 
+	// ARGB slots can also carry PNG or JPEG data.
+	if !bytes.HasPrefix(body, []byte(c.header)) {
+		return ImageCodec.Decode(bytes.NewReader(body), res)
+	}
+
 	flat := rle.Decode(body[len(c.header):]) // skip header
 
 	size := int(res * res)
+	if len(flat) < 4*size {
+		return nil, "", ErrCorrupt
+	}
 	pixels := make([]byte, 4*size)
 	for i := 0; i < size; i++ {
 		pixels[i*4] = flat[size+i]
@@ -21,21 +21,43 @@ import (
 	"image/png"
 	"io"
 	"io/ioutil"
+
+	"yrh.dev/icns/internal/colorspace"
+	"yrh.dev/icns/internal/pngopt"
 )
 
-type imageCodec struct{}
+type imageCodec struct {
+	encoder *png.Encoder
+}
 
 func (c *imageCodec) Encode(w io.Writer, img image.Image) error {
 	// Unconditionally encode as PNG.
-	return png.Encode(w, img)
+	if c.encoder == nil {
+		return pngopt.Encode(w, img)
+	}
+
+	// the standard encoder doesn't know about color space chunks.
+	if t, ok := img.(*colorspace.Tagged); ok {
+		buf := new(bytes.Buffer)
+		if err := c.encoder.Encode(buf, t.Image); err != nil {
+			return err
+		}
+		_, err := w.Write(colorspace.Insert(buf.Bytes(), t.Chunks))
+		return err
+	}
+	return c.encoder.Encode(w, img)
 }
 
-func (c *imageCodec) Decode(r io.Reader, _ Resolution) (image.Image, string, error) {
+func (c *imageCodec) Decode(r io.Reader, res Resolution) (image.Image, string, error) {
 	// we might have to re-read.
 	data, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, "", err
 	}
+	// small modern slots can also carry ARGB data.
+	if bytes.HasPrefix(data, []byte(ARGBCodec.header)) {
+		return ARGBCodec.Decode(bytes.NewReader(data), res)
+	}
 	reader := bytes.NewReader(data)
 	if img, err := jpeg.Decode(reader); err == nil {
 		return img, "jpeg", nil
@@ -49,3 +71,11 @@ func (c *imageCodec) Decode(r io.Reader, _ Resolution) (image.Image, string, err
 }
 
 var ImageCodec = &imageCodec{}
+
+// PNGCodec returns an image codec that encodes PNG data with the standard encoder,
+// at the provided compression level.
+func PNGCodec(level png.CompressionLevel) Codec {
+	return &imageCodec{
+		encoder: &png.Encoder{CompressionLevel: level},
+	}
+}
@@ -41,6 +41,10 @@ func (c *maskCodec) Decode(r io.Reader, res Resolution) (image.Image, string, er
 		return nil, "", err
 	}
 
+	if len(body) < int(res*res) {
+		return nil, "", ErrCorrupt
+	}
+
 	rect := image.Rect(0, 0, int(res), int(res))
 	img := &image.Alpha{
 		Pix:    body,
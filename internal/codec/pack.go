@@ -15,6 +15,7 @@
 package codec
 
 import (
+	"bytes"
 	"image"
 	"io"
 	"io/ioutil"
@@ -23,10 +24,15 @@ import (
 	"yrh.dev/icns/internal/utils"
 )
 
-type packCodec struct{}
+type packCodec struct {
+	header string
+}
 
 func (c *packCodec) Encode(w io.Writer, img image.Image) error {
 	if nrgba, ok := img.(*image.NRGBA); ok {
+		if _, err := io.WriteString(w, c.header); err != nil {
+			return err
+		}
 		for i := 0; i < 3; i++ {
 			c := utils.NRGBAChannel(nrgba, i)
 			if _, err := w.Write(rle.Encode(c)); err != nil {
@@ -44,9 +50,12 @@ func (c *packCodec) Decode(r io.Reader, res Resolution) (image.Image, string, er
 		return nil, "", err
 	}
 
-	flat := rle.Decode(body)
+	flat := rle.Decode(bytes.TrimPrefix(body, []byte(c.header)))
 
 	size := int(res * res)
+	if len(flat) < 3*size {
+		return nil, "", ErrCorrupt
+	}
 	pixels := make([]byte, 4*size)
 	for i := 0; i < size; i++ {
 		pixels[i*4] = flat[i]
@@ -61,7 +70,12 @@ func (c *packCodec) Decode(r io.Reader, res Resolution) (image.Image, string, er
 		Stride: 4 * rect.Dx(),
 		Rect:   rect,
 	}
-	return img, "icon", nil
+	return img, "pack", nil
 }
 
 var PackCodec = &packCodec{}
+
+// PaddedPackCodec is like PackCodec, with the 4 zero bytes it32 data starts with.
+var PaddedPackCodec = &packCodec{
+	header: "\x00\x00\x00\x00",
+}
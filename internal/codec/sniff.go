@@ -0,0 +1,169 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+)
+
+var (
+	pngSignature  = []byte("\x89PNG\r\n\x1a\n")
+	jp2Signature  = []byte("\x00\x00\x00\x0cjP  \r\n\x87\n")
+	j2kSignature  = []byte("\xff\x4f\xff\x51")
+	jpegSignature = []byte("\xff\xd8")
+)
+
+// Sniff identifies the encoding of an encoded image from its header, and
+// returns its dimensions.
+func Sniff(data []byte) (string, int, int, error) {
+	switch {
+	case bytes.HasPrefix(data, pngSignature):
+		// the IHDR chunk must come first.
+		if len(data) < 24 || string(data[12:16]) != "IHDR" {
+			return "", 0, 0, fmt.Errorf("%w: invalid PNG header", ErrCorrupt)
+		}
+		w := binary.BigEndian.Uint32(data[16:])
+		h := binary.BigEndian.Uint32(data[20:])
+		return "png", int(w), int(h), nil
+	case bytes.HasPrefix(data, jp2Signature):
+		w, h, err := jp2Size(data)
+		return "jp2", w, h, err
+	case bytes.HasPrefix(data, j2kSignature):
+		w, h, err := j2kSize(data)
+		return "jp2", w, h, err
+	case bytes.HasPrefix(data, jpegSignature):
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return "", 0, 0, err
+		}
+		return "jpeg", cfg.Width, cfg.Height, nil
+	}
+	return "", 0, 0, ErrUnknownEncoding
+}
+
+// ColorModel returns the color model the encoded image decodes to. PNG data gets
+// the one png.Decode picks for its color type and bit depth, JPEG data the one of
+// jpeg.Decode, anything else NRGBA.
+func ColorModel(data []byte) color.Model {
+	switch {
+	case bytes.HasPrefix(data, pngSignature):
+		cfg, err := png.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return color.NRGBAModel
+		}
+		// DecodeConfig stops before the tRNS chunk of gray and truecolor images,
+		// which makes png.Decode return non-premultiplied ones.
+		if ct := data[25]; (ct == pngGray || ct == pngTrueColor) && hasTransparency(data) {
+			if data[24] == 16 {
+				return color.NRGBA64Model
+			}
+			return color.NRGBAModel
+		}
+		return cfg.ColorModel
+	case bytes.HasPrefix(data, jpegSignature):
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return color.NRGBAModel
+		}
+		return cfg.ColorModel
+	}
+	return color.NRGBAModel
+}
+
+// PNG color types without an alpha channel or a palette.
+const (
+	pngGray      = 0
+	pngTrueColor = 2
+)
+
+// hasTransparency reports whether PNG data has a tRNS chunk before its image data.
+func hasTransparency(data []byte) bool {
+	data = data[len(pngSignature):]
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		switch string(data[4:8]) {
+		case "tRNS":
+			return true
+		case "IDAT":
+			return false
+		}
+		// skip the header, payload and CRC.
+		if size < 0 || size > len(data)-12 {
+			return false
+		}
+		data = data[12+size:]
+	}
+	return false
+}
+
+// jp2Size looks for the image header box inside the JP2 header box.
+func jp2Size(data []byte) (int, int, error) {
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		hdr := 8
+		switch size {
+		case 0: // box extends to the end of the data
+			size = len(data)
+		case 1: // extended size
+			if len(data) < 16 {
+				return 0, 0, fmt.Errorf("%w: invalid JPEG 2000 box", ErrCorrupt)
+			}
+			size = int(binary.BigEndian.Uint64(data[8:]))
+			hdr = 16
+		}
+		if size < hdr || size > len(data) {
+			return 0, 0, fmt.Errorf("%w: invalid JPEG 2000 box", ErrCorrupt)
+		}
+
+		switch typ {
+		case "jp2h":
+			// superbox, look inside.
+			return jp2Size(data[hdr:size])
+		case "ihdr":
+			if size-hdr < 8 {
+				return 0, 0, fmt.Errorf("%w: invalid JPEG 2000 image header", ErrCorrupt)
+			}
+			h := binary.BigEndian.Uint32(data[hdr:])
+			w := binary.BigEndian.Uint32(data[hdr+4:])
+			return int(w), int(h), nil
+		case "jp2c":
+			return j2kSize(data[hdr:size])
+		}
+		data = data[size:]
+	}
+	return 0, 0, fmt.Errorf("%w: missing JPEG 2000 image header", ErrCorrupt)
+}
+
+// j2kSize reads the image size from the SIZ marker of a JPEG 2000 codestream.
+func j2kSize(data []byte) (int, int, error) {
+	if len(data) < 24 || !bytes.HasPrefix(data, j2kSignature) {
+		return 0, 0, fmt.Errorf("%w: invalid JPEG 2000 codestream", ErrCorrupt)
+	}
+	// SOC, SIZ, Lsiz, Rsiz, then the reference grid and image offset.
+	xsiz := binary.BigEndian.Uint32(data[8:])
+	ysiz := binary.BigEndian.Uint32(data[12:])
+	xosiz := binary.BigEndian.Uint32(data[16:])
+	yosiz := binary.BigEndian.Uint32(data[20:])
+	if xosiz > xsiz || yosiz > ysiz {
+		return 0, 0, fmt.Errorf("%w: invalid JPEG 2000 image size", ErrCorrupt)
+	}
+	return int(xsiz - xosiz), int(ysiz - yosiz), nil
+}
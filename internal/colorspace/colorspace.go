@@ -0,0 +1,161 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package colorspace handles the color space information of PNG data, and the
+// conversions between the RGB color spaces used by icons.
+//
+// Only the chunks describing the color space are considered: iCCP, sRGB, gAMA
+// and cHRM. They are kept as is, so that re-encoded images are tagged exactly
+// like the original ones.
+package colorspace
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"io"
+	"io/ioutil"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+// Chunk is an ancillary PNG chunk describing the color space of the image data.
+type Chunk struct {
+	Type string
+	Data []byte
+}
+
+func isColorChunk(t string) bool {
+	switch t {
+	case "iCCP", "sRGB", "gAMA", "cHRM":
+		return true
+	}
+	return false
+}
+
+// Read returns the color space chunks of PNG data, in file order. It returns nil
+// for untagged images, or if the data is not PNG.
+func Read(data []byte) []Chunk {
+	if !bytes.HasPrefix(data, pngHeader) {
+		return nil
+	}
+	data = data[len(pngHeader):]
+
+	var res []Chunk
+	for len(data) >= 12 {
+		n := binary.BigEndian.Uint32(data)
+		t := string(data[4:8])
+		if uint64(n)+12 > uint64(len(data)) {
+			break
+		}
+		// these chunks must come before the image data.
+		if t == "IDAT" {
+			break
+		}
+		if isColorChunk(t) {
+			res = append(res, Chunk{Type: t, Data: append([]byte(nil), data[8:8+n]...)})
+		}
+		data = data[12+n:]
+	}
+	return res
+}
+
+// Insert adds the chunks to PNG data, right after its header chunk.
+func Insert(data []byte, chunks []Chunk) []byte {
+	// the IHDR chunk is always first, with a fixed size.
+	const ihdrEnd = 8 + 12 + 13
+	if len(chunks) == 0 || len(data) < ihdrEnd || !bytes.HasPrefix(data, pngHeader) {
+		return data
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)+256))
+	buf.Write(data[:ihdrEnd])
+	for _, c := range chunks {
+		_ = WriteChunk(buf, c)
+	}
+	buf.Write(data[ihdrEnd:])
+	return buf.Bytes()
+}
+
+// WriteChunk writes a PNG chunk, along with its length and checksum.
+func WriteChunk(w io.Writer, c Chunk) error {
+	hdr := make([]byte, 8)
+	binary.BigEndian.PutUint32(hdr[0:], uint32(len(c.Data)))
+	copy(hdr[4:], c.Type)
+
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write(hdr[4:])
+	_, _ = crc.Write(c.Data)
+	tail := make([]byte, 4)
+	binary.BigEndian.PutUint32(tail, crc.Sum32())
+
+	for _, b := range [][]byte{hdr, c.Data, tail} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Profile returns the name and the uncompressed data of the embedded ICC
+// profile, if any.
+func Profile(chunks []Chunk) (string, []byte) {
+	for _, c := range chunks {
+		if c.Type != "iCCP" {
+			continue
+		}
+		idx := bytes.IndexByte(c.Data, 0)
+		// the name is followed by the compression method, always 0 (zlib).
+		if idx < 0 || idx+2 > len(c.Data) {
+			return "", nil
+		}
+		name := string(c.Data[:idx])
+		zr, err := zlib.NewReader(bytes.NewReader(c.Data[idx+2:]))
+		if err != nil {
+			return name, nil
+		}
+		profile, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return name, nil
+		}
+		return name, profile
+	}
+	return "", nil
+}
+
+// Name returns a short description of the color space: "sRGB" for images tagged
+// as such, the name of the embedded ICC profile if any, "calibrated" for images
+// only tagged with a gamma or chromaticities, and "" for untagged images.
+func Name(chunks []Chunk) string {
+	if len(chunks) == 0 {
+		return ""
+	}
+	for _, c := range chunks {
+		if c.Type == "sRGB" {
+			return "sRGB"
+		}
+	}
+	if name, _ := Profile(chunks); name != "" {
+		return name
+	}
+	return "calibrated"
+}
+
+// Tagged is an image to be encoded along with color space chunks.
+type Tagged struct {
+	image.Image
+	Chunks []Chunk
+}
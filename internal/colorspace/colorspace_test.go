@@ -0,0 +1,93 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package colorspace_test
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"yrh.dev/icns/internal/colorspace"
+)
+
+func TestInsertRead(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	if got := colorspace.Read(buf.Bytes()); got != nil {
+		t.Errorf("Read() of untagged data returned %v", got)
+	}
+
+	for _, s := range []*colorspace.Space{colorspace.SRGB, colorspace.DisplayP3} {
+		data := colorspace.Insert(buf.Bytes(), s.Chunks)
+		if diff := cmp.Diff(s.Chunks, colorspace.Read(data)); diff != "" {
+			t.Errorf("%s: Read() mismatch (-want +got):\n%s", s.Name, diff)
+		}
+		if got := colorspace.Name(s.Chunks); got != s.Name {
+			t.Errorf("Name() returned %q, want %q", got, s.Name)
+		}
+		// the standard decoder checks the chunk checksums.
+		if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+			t.Errorf("%s: invalid PNG data: %v", s.Name, err)
+		}
+	}
+
+	name, profile := colorspace.Profile(colorspace.DisplayP3.Chunks)
+	if name != "Display P3" || len(profile) < 128 || int(profile[3])|int(profile[2])<<8 != len(profile) {
+		t.Errorf("unexpected profile %q: % x", name, profile)
+	}
+}
+
+func TestConversion(t *testing.T) {
+	t.Parallel()
+
+	m := colorspace.Conversion(colorspace.SRGB, colorspace.DisplayP3)
+	want := colorspace.Matrix{
+		0.8224621, 0.1775380, 0,
+		0.0331941, 0.9668058, 0,
+		0.0170827, 0.0723974, 0.9105199,
+	}
+	for idx := range m {
+		if math.Abs(m[idx]-want[idx]) > 1e-3 {
+			t.Fatalf("unexpected conversion matrix: got %v, want %v", m, want)
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for idx := range img.Pix {
+		img.Pix[idx] = uint8(idx * 7)
+	}
+	p3 := colorspace.Convert(img, colorspace.SRGB, colorspace.DisplayP3).(*image.NRGBA)
+	back := colorspace.Convert(p3, colorspace.DisplayP3, colorspace.SRGB).(*image.NRGBA)
+	// 8-bit intermediate values lose some precision, especially in dark tones.
+	for idx := range img.Pix {
+		if d := int(back.Pix[idx]) - int(img.Pix[idx]); d < -2 || d > 2 {
+			x, y := idx/4%16, idx/64
+			t.Fatalf("round trip changed pixel (%d, %d): got %v, want %v", x, y, back.At(x, y), img.At(x, y))
+		}
+	}
+
+	for idx := 3; idx < len(img.Pix); idx += 4 {
+		if p3.Pix[idx] != img.Pix[idx] {
+			t.Fatalf("alpha changed by the conversion: got %d, want %d", p3.Pix[idx], img.Pix[idx])
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package colorspace
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"yrh.dev/icns/internal/utils"
+)
+
+// Space is an RGB color space with a D65 white point, using the sRGB transfer function.
+type Space struct {
+	Name string
+	// toXYZ converts linear RGB values to CIE XYZ, row major.
+	toXYZ [9]float64
+	// Chunks tag PNG data as being in that color space.
+	Chunks []Chunk
+}
+
+var (
+	// SRGB is the default color space of untagged images.
+	SRGB = &Space{
+		Name: "sRGB",
+		toXYZ: [9]float64{
+			0.4124564, 0.3575761, 0.1804375,
+			0.2126729, 0.7151522, 0.0721750,
+			0.0193339, 0.1191920, 0.9503041,
+		},
+		// the PNG specification recommends writing gAMA and cHRM along sRGB.
+		Chunks: []Chunk{
+			{Type: "sRGB", Data: []byte{0}}, // perceptual intent
+			{Type: "gAMA", Data: uint32s(45455)},
+			{Type: "cHRM", Data: uint32s(31270, 32900, 64000, 33000, 30000, 60000, 15000, 6000)},
+		},
+	}
+
+	// DisplayP3 is the wide gamut color space of Apple displays.
+	DisplayP3 = &Space{
+		Name: "Display P3",
+		toXYZ: [9]float64{
+			0.4865709, 0.2656677, 0.1982173,
+			0.2289746, 0.6917385, 0.0792869,
+			0.0000000, 0.0451134, 1.0439444,
+		},
+		Chunks: []Chunk{iccp("Display P3", displayP3Profile())},
+	}
+)
+
+func uint32s(values ...uint32) []byte {
+	res := make([]byte, 4*len(values))
+	for idx, v := range values {
+		binary.BigEndian.PutUint32(res[4*idx:], v)
+	}
+	return res
+}
+
+// iccp builds an iCCP chunk holding the provided profile.
+func iccp(name string, profile []byte) Chunk {
+	buf := new(bytes.Buffer)
+	buf.WriteString(name)
+	buf.Write([]byte{0, 0}) // name terminator, zlib compression
+	zw, _ := zlib.NewWriterLevel(buf, zlib.BestCompression)
+	_, _ = zw.Write(profile)
+	_ = zw.Close()
+	return Chunk{Type: "iCCP", Data: buf.Bytes()}
+}
+
+// s15 converts a value to the s15Fixed16Number ICC type.
+func s15(v float64) uint32 {
+	return uint32(int32(math.Round(v * 65536)))
+}
+
+// displayP3Profile builds a minimal ICC v4 display profile for Display P3.
+// The values are those of the profile shipped with macOS.
+func displayP3Profile() []byte {
+	xyz := func(x, y, z float64) []byte {
+		return append([]byte("XYZ \x00\x00\x00\x00"), uint32s(s15(x), s15(y), s15(z))...)
+	}
+	mluc := func(s string) []byte {
+		b := []byte("mluc\x00\x00\x00\x00")
+		b = append(b, uint32s(1, 12)...)
+		b = append(b, "enUS"...)
+		b = append(b, uint32s(uint32(2*len(s)), 28)...)
+		for _, r := range s {
+			b = append(b, 0, byte(r))
+		}
+		return b
+	}
+	// parametric curve of the sRGB transfer function.
+	para := append([]byte("para\x00\x00\x00\x00\x00\x03\x00\x00"),
+		uint32s(s15(2.4), s15(1/1.055), s15(0.055/1.055), s15(1/12.92), s15(0.04045))...)
+	chad := append([]byte("sf32\x00\x00\x00\x00"), uint32s(
+		s15(1.047882), s15(0.022919), s15(-0.050201),
+		s15(0.029587), s15(0.990479), s15(-0.017059),
+		s15(-0.009232), s15(0.015076), s15(0.751678),
+	)...)
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", mluc("Display P3")},
+		{"cprt", mluc("No copyright, use freely")},
+		{"wtpt", xyz(0.964203, 1, 0.824905)},
+		{"rXYZ", xyz(0.515121, 0.241196, -0.001053)},
+		{"gXYZ", xyz(0.291977, 0.692245, 0.041885)},
+		{"bXYZ", xyz(0.157104, 0.066574, 0.784073)},
+		{"rTRC", para},
+		{"gTRC", nil}, // shares the red curve
+		{"bTRC", nil},
+		{"chad", chad},
+	}
+
+	header := make([]byte, 128)
+	copy(header[8:], uint32s(0x04300000))
+	copy(header[12:], "mntrRGB XYZ ")
+	copy(header[24:], []byte{0x07, 0xe5, 0, 1, 0, 1}) // 2021-01-01
+	copy(header[36:], "acspAPPL")
+	copy(header[68:], uint32s(s15(0.9642), s15(1), s15(0.8249)))
+
+	table := uint32s(uint32(len(tags)))
+	var data []byte
+	offset := len(header) + 4 + 12*len(tags)
+	var last, lastSize int
+	for _, t := range tags {
+		if t.data != nil {
+			last, lastSize = offset+len(data), len(t.data)
+			data = append(data, t.data...)
+			// tag data is 4-byte aligned.
+			for len(data)%4 != 0 {
+				data = append(data, 0)
+			}
+		}
+		table = append(table, t.sig...)
+		table = append(table, uint32s(uint32(last), uint32(lastSize))...)
+	}
+
+	res := append(header, table...)
+	res = append(res, data...)
+	binary.BigEndian.PutUint32(res, uint32(len(res)))
+	return res
+}
+
+// Matrix is a 3x3 matrix, row major.
+type Matrix [9]float64
+
+func (m Matrix) mul(n Matrix) Matrix {
+	var res Matrix
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			for k := 0; k < 3; k++ {
+				res[3*r+c] += m[3*r+k] * n[3*k+c]
+			}
+		}
+	}
+	return res
+}
+
+func (m Matrix) inverse() Matrix {
+	det := m[0]*(m[4]*m[8]-m[5]*m[7]) - m[1]*(m[3]*m[8]-m[5]*m[6]) + m[2]*(m[3]*m[7]-m[4]*m[6])
+	return Matrix{
+		(m[4]*m[8] - m[5]*m[7]) / det,
+		(m[2]*m[7] - m[1]*m[8]) / det,
+		(m[1]*m[5] - m[2]*m[4]) / det,
+		(m[5]*m[6] - m[3]*m[8]) / det,
+		(m[0]*m[8] - m[2]*m[6]) / det,
+		(m[2]*m[3] - m[0]*m[5]) / det,
+		(m[3]*m[7] - m[4]*m[6]) / det,
+		(m[1]*m[6] - m[0]*m[7]) / det,
+		(m[0]*m[4] - m[1]*m[3]) / det,
+	}
+}
+
+// Conversion returns the matrix converting linear RGB values from src to dst.
+func Conversion(src, dst *Space) Matrix {
+	return Matrix(dst.toXYZ).inverse().mul(Matrix(src.toXYZ))
+}
+
+func toLinear(c float64) float64 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func fromLinear(c float64) float64 {
+	if c <= 0.0031308 {
+		return c * 12.92
+	}
+	return 1.055*math.Pow(c, 1/2.4) - 0.055
+}
+
+// Convert converts the colors of the image from src to dst. Colors out of the
+// destination gamut are clipped. Alpha values are left untouched.
+// The result is an *image.NRGBA64 for 16-bit images, and an *image.NRGBA otherwise.
+func Convert(img image.Image, src, dst *Space) draw.Image {
+	m := Conversion(src, dst)
+	r := img.Bounds()
+	rect := image.Rect(0, 0, r.Dx(), r.Dy())
+
+	deep := utils.Is16Bit(img)
+	var res draw.Image = image.NewNRGBA(rect)
+	if deep {
+		res = image.NewNRGBA64(rect)
+	}
+
+	// icons have few distinct colors compared to their pixel count.
+	cache := make(map[color.NRGBA64]color.NRGBA64)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			out, ok := cache[c]
+			if !ok {
+				in := [3]float64{
+					toLinear(float64(c.R) / 0xffff),
+					toLinear(float64(c.G) / 0xffff),
+					toLinear(float64(c.B) / 0xffff),
+				}
+				var v [3]uint16
+				for ch := 0; ch < 3; ch++ {
+					l := m[3*ch]*in[0] + m[3*ch+1]*in[1] + m[3*ch+2]*in[2]
+					v[ch] = uint16(math.Round(fromLinear(math.Max(0, math.Min(1, l))) * 0xffff))
+				}
+				out = color.NRGBA64{v[0], v[1], v[2], c.A}
+				cache[c] = out
+			}
+			if !deep {
+				// round rather than truncate to 8 bits.
+				res.Set(x-r.Min.X, y-r.Min.Y, color.NRGBA{to8(out.R), to8(out.G), to8(out.B), to8(out.A)})
+				continue
+			}
+			res.Set(x-r.Min.X, y-r.Min.Y, out)
+		}
+	}
+	return res
+}
+
+func to8(v uint16) uint8 {
+	return uint8((uint32(v)*0xff + 0x7fff) / 0xffff)
+}
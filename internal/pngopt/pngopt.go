@@ -0,0 +1,483 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pngopt implements a lossless, size-optimizing PNG encoder.
+//
+// The encoder analyzes the image to pick the most compact color type that can
+// represent it exactly:
+// - grayscale (at the lowest possible bit depth) if all pixels are opaque grays
+// - grayscale with alpha if all pixels are grays
+// - palette (with a tRNS chunk if needed) if there are at most 256 colors
+// - truecolor, with an alpha channel only if some pixels are not opaque
+// Each candidate is then filtered with several strategies and compressed at the
+// highest level, and the smallest result is kept.
+// The only ancillary chunks ever written are the color space ones of tagged
+// images, see colorspace.Tagged.
+package pngopt
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"sort"
+
+	"yrh.dev/icns/internal/colorspace"
+	"yrh.dev/icns/internal/utils"
+)
+
+const (
+	ctGray      = 0
+	ctTrueColor = 2
+	ctPalette   = 3
+	ctGrayAlpha = 4
+	ctRGBA      = 6
+)
+
+type raw struct {
+	colorType byte
+	depth     int
+	channels  int
+	palette   []uint32 // NRGBA colors, packed as 0xRRGGBBAA
+	rows      [][]byte
+}
+
+// bpp returns the number of bytes per complete pixel, rounded up to 1.
+func (r *raw) bpp() int {
+	n := r.channels * r.depth / 8
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// overhead returns the size of the extra chunks needed for that representation.
+func (r *raw) overhead() int {
+	if r.colorType != ctPalette {
+		return 0
+	}
+	n := 12 + 3*len(r.palette)
+	trns := 0
+	for _, c := range r.palette {
+		if c&0xff != 0xff {
+			trns++
+		}
+	}
+	if trns > 0 {
+		n += 12 + trns
+	}
+	return n
+}
+
+// Encode writes the image to w in PNG format, choosing the most compact lossless representation.
+func Encode(w io.Writer, img image.Image) error {
+	var tags []colorspace.Chunk
+	if t, ok := img.(*colorspace.Tagged); ok {
+		img, tags = t.Image, t.Chunks
+	}
+
+	if isDeep(img) {
+		// keep the extra precision, the standard encoder knows how to do that.
+		buf := new(bytes.Buffer)
+		if err := png.Encode(buf, img); err != nil {
+			return err
+		}
+		_, err := w.Write(colorspace.Insert(buf.Bytes(), tags))
+		return err
+	}
+
+	nrgba := utils.Img2NRGBA(img)
+
+	var best []byte
+	var bestRaw *raw
+	for _, r := range candidates(nrgba) {
+		for _, adaptive := range []bool{false, true} {
+			data, err := compress(r, adaptive)
+			if err != nil {
+				return err
+			}
+			if best == nil || len(data)+r.overhead() < len(best)+bestRaw.overhead() {
+				best = data
+				bestRaw = r
+			}
+		}
+	}
+
+	return write(w, nrgba.Rect, bestRaw, best, tags)
+}
+
+// isDeep reports whether the image actually uses more than 8 bits per channel.
+func isDeep(img image.Image) bool {
+	var pix []byte
+	switch i := img.(type) {
+	case *image.NRGBA64:
+		pix = i.Pix
+	case *image.RGBA64:
+		pix = i.Pix
+	case *image.Gray16:
+		pix = i.Pix
+	default:
+		return false
+	}
+
+	for idx := 0; idx < len(pix); idx += 2 {
+		if pix[idx] != pix[idx+1] {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates returns the possible exact representations of the image.
+func candidates(img *image.NRGBA) []*raw {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+
+	opaque, gray := true, true
+	colors := make(map[uint32]int)
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+4*w]
+		for x := 0; x < w; x++ {
+			p := row[4*x : 4*x+4]
+			if p[3] != 0xff {
+				opaque = false
+			}
+			if p[0] != p[1] || p[1] != p[2] {
+				gray = false
+			}
+			if colors != nil {
+				colors[pack(p)]++
+				if len(colors) > 256 {
+					colors = nil
+				}
+			}
+		}
+	}
+
+	var res []*raw
+	if colors != nil {
+		res = append(res, paletted(img, colors))
+	}
+
+	switch {
+	case gray && opaque:
+		res = append(res, grayscale(img))
+	case gray:
+		res = append(res, build(img, ctGrayAlpha, []int{0, 3}))
+	case opaque:
+		res = append(res, build(img, ctTrueColor, []int{0, 1, 2}))
+	default:
+		res = append(res, build(img, ctRGBA, []int{0, 1, 2, 3}))
+	}
+	return res
+}
+
+func pack(p []byte) uint32 {
+	return uint32(p[0])<<24 | uint32(p[1])<<16 | uint32(p[2])<<8 | uint32(p[3])
+}
+
+// build extracts the provided channels of the image as 8-bit samples.
+func build(img *image.NRGBA, ct byte, channels []int) *raw {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	r := &raw{
+		colorType: ct,
+		depth:     8,
+		channels:  len(channels),
+		rows:      make([][]byte, h),
+	}
+
+	for y := 0; y < h; y++ {
+		src := img.Pix[y*img.Stride : y*img.Stride+4*w]
+		row := make([]byte, 0, w*len(channels))
+		for x := 0; x < w; x++ {
+			for _, c := range channels {
+				row = append(row, src[4*x+c])
+			}
+		}
+		r.rows[y] = row
+	}
+	return r
+}
+
+// grayscale builds an opaque gray representation, at the lowest depth that's exact.
+func grayscale(img *image.NRGBA) *raw {
+	r := build(img, ctGray, []int{0})
+
+	for _, depth := range []int{1, 2, 4} {
+		// samples of depth d are scaled by 255/(2^d-1) when decoded.
+		scale := 255 / (1<<uint(depth) - 1)
+		exact := true
+		for _, row := range r.rows {
+			for _, v := range row {
+				if int(v)%scale != 0 {
+					exact = false
+					break
+				}
+			}
+			if !exact {
+				break
+			}
+		}
+
+		if exact {
+			for y, row := range r.rows {
+				samples := make([]int, len(row))
+				for x, v := range row {
+					samples[x] = int(v) / scale
+				}
+				r.rows[y] = packBits(samples, depth)
+			}
+			r.depth = depth
+			break
+		}
+	}
+	return r
+}
+
+// paletted builds a palette representation, with the transparent colors first so
+// that the tRNS chunk is as short as possible.
+func paletted(img *image.NRGBA, colors map[uint32]int) *raw {
+	palette := make([]uint32, 0, len(colors))
+	for c := range colors {
+		palette = append(palette, c)
+	}
+	sort.Slice(palette, func(i, j int) bool {
+		ti, tj := palette[i]&0xff != 0xff, palette[j]&0xff != 0xff
+		if ti != tj {
+			return ti
+		}
+		if colors[palette[i]] != colors[palette[j]] {
+			return colors[palette[i]] > colors[palette[j]]
+		}
+		return palette[i] < palette[j]
+	})
+
+	index := make(map[uint32]int, len(palette))
+	for idx, c := range palette {
+		index[c] = idx
+	}
+
+	depth := 8
+	for _, d := range []int{1, 2, 4} {
+		if len(palette) <= 1<<uint(d) {
+			depth = d
+			break
+		}
+	}
+
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	r := &raw{
+		colorType: ctPalette,
+		depth:     depth,
+		channels:  1,
+		palette:   palette,
+		rows:      make([][]byte, h),
+	}
+	for y := 0; y < h; y++ {
+		src := img.Pix[y*img.Stride : y*img.Stride+4*w]
+		samples := make([]int, w)
+		for x := 0; x < w; x++ {
+			samples[x] = index[pack(src[4*x:4*x+4])]
+		}
+		r.rows[y] = packBits(samples, depth)
+	}
+	return r
+}
+
+// packBits packs samples of the provided bit depth, most significant bits first.
+func packBits(samples []int, depth int) []byte {
+	if depth == 8 {
+		res := make([]byte, len(samples))
+		for i, s := range samples {
+			res[i] = byte(s)
+		}
+		return res
+	}
+
+	perByte := 8 / depth
+	res := make([]byte, (len(samples)+perByte-1)/perByte)
+	for i, s := range samples {
+		shift := uint(8 - depth*(i%perByte+1))
+		res[i/perByte] |= byte(s << shift)
+	}
+	return res
+}
+
+// compress filters and deflates the image data.
+// Without adaptive filtering, no filter is applied at all.
+func compress(r *raw, adaptive bool) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	zw, err := zlib.NewWriterLevel(buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	bpp := r.bpp()
+	var prev []byte
+	for _, row := range r.rows {
+		if prev == nil {
+			prev = make([]byte, len(row))
+		}
+
+		ft, filtered := byte(0), row
+		if adaptive {
+			ft, filtered = filter(row, prev, bpp)
+		}
+
+		if _, err := zw.Write([]byte{ft}); err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write(filtered); err != nil {
+			return nil, err
+		}
+		prev = row
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// filter applies the filter type that minimizes the sum of absolute differences.
+func filter(row, prev []byte, bpp int) (byte, []byte) {
+	var best []byte
+	var bestType byte
+	bestSum := -1
+
+	for ft := byte(0); ft < 5; ft++ {
+		out := make([]byte, len(row))
+		sum := 0
+		for i := range row {
+			var a, c int
+			if i >= bpp {
+				a = int(row[i-bpp])
+				c = int(prev[i-bpp])
+			}
+			b := int(prev[i])
+
+			var pred int
+			switch ft {
+			case 1:
+				pred = a
+			case 2:
+				pred = b
+			case 3:
+				pred = (a + b) / 2
+			case 4:
+				pred = paeth(a, b, c)
+			}
+
+			out[i] = row[i] - byte(pred)
+			if v := int(int8(out[i])); v < 0 {
+				sum -= v
+			} else {
+				sum += v
+			}
+		}
+
+		if bestSum < 0 || sum < bestSum {
+			best, bestType, bestSum = out, ft, sum
+		}
+	}
+	return bestType, best
+}
+
+func paeth(a, b, c int) int {
+	p := a + b - c
+	pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// write outputs the final PNG stream.
+func write(w io.Writer, rect image.Rectangle, r *raw, data []byte, tags []colorspace.Chunk) error {
+	if _, err := w.Write([]byte("\x89PNG\r\n\x1a\n")); err != nil {
+		return err
+	}
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(rect.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(rect.Dy()))
+	ihdr[8] = byte(r.depth)
+	ihdr[9] = r.colorType
+	// compression, filter and interlace methods are all 0.
+	if err := writeChunk(w, "IHDR", ihdr); err != nil {
+		return err
+	}
+
+	// color space chunks must come before the palette.
+	for _, c := range tags {
+		if err := colorspace.WriteChunk(w, c); err != nil {
+			return err
+		}
+	}
+
+	if r.colorType == ctPalette {
+		plte := make([]byte, 0, 3*len(r.palette))
+		var trns []byte
+		for _, c := range r.palette {
+			plte = append(plte, byte(c>>24), byte(c>>16), byte(c>>8))
+			if a := byte(c); a != 0xff {
+				trns = append(trns, a)
+			}
+		}
+		if err := writeChunk(w, "PLTE", plte); err != nil {
+			return err
+		}
+		if len(trns) > 0 {
+			if err := writeChunk(w, "tRNS", trns); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := writeChunk(w, "IDAT", data); err != nil {
+		return err
+	}
+	return writeChunk(w, "IEND", nil)
+}
+
+func writeChunk(w io.Writer, name string, data []byte) error {
+	hdr := make([]byte, 8)
+	binary.BigEndian.PutUint32(hdr[0:], uint32(len(data)))
+	copy(hdr[4:], name)
+
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write(hdr[4:])
+	_, _ = crc.Write(data)
+	tail := make([]byte, 4)
+	binary.BigEndian.PutUint32(tail, crc.Sum32())
+
+	for _, b := range [][]byte{hdr, data, tail} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pngopt_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"yrh.dev/icns/internal/pngopt"
+	"yrh.dev/icns/internal/utils"
+)
+
+func fill(size int, f func(x, y int) color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.SetNRGBA(x, y, f(x, y))
+		}
+	}
+	return img
+}
+
+func TestEncode(t *testing.T) {
+	data := []struct {
+		name      string
+		img       *image.NRGBA
+		colorType byte
+		depth     byte
+	}{
+		{
+			"black and white",
+			fill(16, func(x, y int) color.NRGBA {
+				v := uint8((x + y) % 2 * 0xff)
+				return color.NRGBA{v, v, v, 0xff}
+			}),
+			0, 1,
+		},
+		{
+			"gray",
+			fill(32, func(x, y int) color.NRGBA {
+				v := uint8(x*8 + y)
+				return color.NRGBA{v, v, v, 0xff}
+			}),
+			0, 8,
+		},
+		{
+			"gray alpha",
+			fill(32, func(x, y int) color.NRGBA {
+				return color.NRGBA{uint8(x * 8), uint8(x * 8), uint8(x * 8), uint8(y * 8)}
+			}),
+			4, 8,
+		},
+		{
+			"palette with transparency",
+			fill(64, func(x, y int) color.NRGBA {
+				i := (x*x*7 + y*13) % 12
+				return color.NRGBA{uint8(i * 20), 0x20, uint8(i * i), uint8(i % 3 * 0x7f)}
+			}),
+			3, 4,
+		},
+		{
+			"opaque truecolor",
+			fill(32, func(x, y int) color.NRGBA {
+				return color.NRGBA{uint8(x * 8), uint8(y * 8), uint8(x * y), 0xff}
+			}),
+			2, 8,
+		},
+		{
+			"truecolor with alpha",
+			fill(32, func(x, y int) color.NRGBA {
+				return color.NRGBA{uint8(x * 8), uint8(y * 8), uint8(x * y), uint8(x + y)}
+			}),
+			6, 8,
+		},
+	}
+
+	for _, tt := range data {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			buf := new(bytes.Buffer)
+			if err := pngopt.Encode(buf, tt.img); err != nil {
+				t.Fatal(err)
+			}
+
+			// IHDR data starts right after the signature and chunk header.
+			hdr := buf.Bytes()[16:29]
+			if hdr[9] != tt.colorType || hdr[8] != tt.depth {
+				t.Errorf("unexpected color type/depth: got %d/%d, want %d/%d", hdr[9], hdr[8], tt.colorType, tt.depth)
+			}
+
+			ref := new(bytes.Buffer)
+			if err := png.Encode(ref, tt.img); err != nil {
+				t.Fatal(err)
+			}
+			if buf.Len() > ref.Len() {
+				t.Errorf("optimized output is larger than the standard one: got %d, want at most %d", buf.Len(), ref.Len())
+			}
+
+			dec, err := png.Decode(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !utils.SamePixels(tt.img, dec) {
+				t.Error("decoded image differs from source")
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package quantize implements a median-cut color quantizer.
+//
+// All the computations happen on alpha-premultiplied values, so that the
+// color of mostly transparent pixels weighs less than the color of opaque ones.
+package quantize
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+type sample struct {
+	c     [4]float64 // premultiplied RGBA
+	count int
+}
+
+type box struct {
+	samples []sample
+}
+
+// widest returns the channel with the largest range, and that range.
+func (b *box) widest() (int, float64) {
+	var ch int
+	var width float64 = -1
+	for c := 0; c < 4; c++ {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, s := range b.samples {
+			lo = math.Min(lo, s.c[c])
+			hi = math.Max(hi, s.c[c])
+		}
+		if hi-lo > width {
+			ch, width = c, hi-lo
+		}
+	}
+	return ch, width
+}
+
+// split cuts the box at the median of its widest channel.
+func (b *box) split() (*box, *box) {
+	ch, _ := b.widest()
+	sort.Slice(b.samples, func(i, j int) bool {
+		return b.samples[i].c[ch] < b.samples[j].c[ch]
+	})
+
+	total := 0
+	for _, s := range b.samples {
+		total += s.count
+	}
+
+	acc, idx := 0, 0
+	for idx < len(b.samples)-1 {
+		acc += b.samples[idx].count
+		idx++
+		if 2*acc >= total {
+			break
+		}
+	}
+	return &box{b.samples[:idx]}, &box{b.samples[idx:]}
+}
+
+// mean returns the weighted average color of the box.
+func (b *box) mean() [4]float64 {
+	var res [4]float64
+	total := 0
+	for _, s := range b.samples {
+		for c := 0; c < 4; c++ {
+			res[c] += s.c[c] * float64(s.count)
+		}
+		total += s.count
+	}
+	for c := 0; c < 4; c++ {
+		res[c] /= float64(total)
+	}
+	return res
+}
+
+func premultiply(p []byte) [4]float64 {
+	a := float64(p[3]) / 255
+	return [4]float64{float64(p[0]) * a, float64(p[1]) * a, float64(p[2]) * a, float64(p[3])}
+}
+
+func distance(a, b [4]float64) float64 {
+	var d float64
+	for c := 0; c < 4; c++ {
+		d += (a[c] - b[c]) * (a[c] - b[c])
+	}
+	return d
+}
+
+// palette computes at most n representative colors for the image.
+func palette(img *image.NRGBA, n int) [][4]float64 {
+	counts := make(map[[4]byte]int)
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+4*w]
+		for x := 0; x < w; x++ {
+			var k [4]byte
+			copy(k[:], row[4*x:4*x+4])
+			counts[k]++
+		}
+	}
+
+	samples := make([]sample, 0, len(counts))
+	for k, cnt := range counts {
+		samples = append(samples, sample{premultiply(k[:]), cnt})
+	}
+	// map iteration order is random, keep the output deterministic.
+	sort.Slice(samples, func(i, j int) bool {
+		for c := 0; c < 4; c++ {
+			if samples[i].c[c] != samples[j].c[c] {
+				return samples[i].c[c] < samples[j].c[c]
+			}
+		}
+		return false
+	})
+
+	boxes := []*box{{samples}}
+	for len(boxes) < n {
+		// split the box with the widest range that can still be split.
+		best := -1
+		var bestWidth float64
+		for idx, b := range boxes {
+			if len(b.samples) < 2 {
+				continue
+			}
+			if _, width := b.widest(); width > bestWidth {
+				best, bestWidth = idx, width
+			}
+		}
+		if best < 0 {
+			break
+		}
+		b1, b2 := boxes[best].split()
+		boxes[best] = b1
+		boxes = append(boxes, b2)
+	}
+
+	res := make([][4]float64, len(boxes))
+	for idx, b := range boxes {
+		res[idx] = b.mean()
+	}
+	return res
+}
+
+// toNRGBA converts a premultiplied color back to non-premultiplied 8-bit values.
+func toNRGBA(c [4]float64) [4]byte {
+	clamp := func(v float64) byte {
+		return byte(math.Max(0, math.Min(255, math.Round(v))))
+	}
+	a := clamp(c[3])
+	if a == 0 {
+		return [4]byte{}
+	}
+	f := 255 / float64(a)
+	return [4]byte{clamp(c[0] * f), clamp(c[1] * f), clamp(c[2] * f), a}
+}
+
+// Quantize reduces the image to at most n colors, optionally applying
+// Floyd-Steinberg dithering. It returns the quantized image along with its root
+// mean square error relative to the source, in 8-bit premultiplied units.
+func Quantize(img *image.NRGBA, n int, dither bool) (*image.NRGBA, float64) {
+	pal := palette(img, n)
+
+	// work with the exact values that will end up in the image.
+	entries := make([][4]byte, len(pal))
+	for idx, c := range pal {
+		entries[idx] = toNRGBA(c)
+		pal[idx] = premultiply(entries[idx][:])
+	}
+
+	nearest := func(c [4]float64) int {
+		best, bestDist := 0, math.Inf(1)
+		for idx, p := range pal {
+			if d := distance(c, p); d < bestDist {
+				best, bestDist = idx, d
+			}
+		}
+		return best
+	}
+
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	res := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	// error diffusion buffers for the current and next rows.
+	cur := make([][4]float64, w+2)
+	next := make([][4]float64, w+2)
+	cache := make(map[[4]byte]int)
+
+	var sum float64
+	for y := 0; y < h; y++ {
+		src := img.Pix[y*img.Stride : y*img.Stride+4*w]
+		dst := res.Pix[y*res.Stride : y*res.Stride+4*w]
+		for x := 0; x < w; x++ {
+			orig := premultiply(src[4*x : 4*x+4])
+
+			var idx int
+			if dither {
+				c := orig
+				for ch := 0; ch < 4; ch++ {
+					c[ch] += cur[x+1][ch]
+				}
+				idx = nearest(c)
+				for ch := 0; ch < 4; ch++ {
+					e := c[ch] - pal[idx][ch]
+					cur[x+2][ch] += e * 7 / 16
+					next[x][ch] += e * 3 / 16
+					next[x+1][ch] += e * 5 / 16
+					next[x+2][ch] += e * 1 / 16
+				}
+			} else {
+				var k [4]byte
+				copy(k[:], src[4*x:4*x+4])
+				var ok bool
+				if idx, ok = cache[k]; !ok {
+					idx = nearest(orig)
+					cache[k] = idx
+				}
+			}
+
+			copy(dst[4*x:4*x+4], entries[idx][:])
+			sum += distance(orig, pal[idx])
+		}
+		cur, next = next, cur
+		for x := range next {
+			next[x] = [4]float64{}
+		}
+	}
+
+	if w*h == 0 {
+		return res, 0
+	}
+	return res, math.Sqrt(sum / float64(4*w*h))
+}
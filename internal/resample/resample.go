@@ -0,0 +1,305 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package resample implements separable image resampling.
+//
+// Pixels are alpha-premultiplied before filtering, so that the color of
+// transparent pixels doesn't bleed into their neighbors. Filtering can also
+// happen on linear light values rather than sRGB-encoded ones, which avoids
+// darkening high contrast edges when downsampling.
+package resample
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// Filter is a resampling kernel.
+type Filter struct {
+	// Support is the radius of the kernel, in source pixels when upsampling.
+	Support float64
+	// Kernel returns the weight of a sample at the provided distance.
+	Kernel func(float64) float64
+}
+
+// CatmullRom is the cubic filter with B=0, C=0.5. It is sharp, with little ringing.
+var CatmullRom = Filter{
+	Support: 2,
+	Kernel: func(x float64) float64 {
+		x = math.Abs(x)
+		if x < 1 {
+			return (3*x*x*x - 5*x*x + 2) / 2
+		}
+		if x < 2 {
+			return (-x*x*x + 5*x*x - 8*x + 4) / 2
+		}
+		return 0
+	},
+}
+
+// Lanczos is the Lanczos filter with 3 lobes. It is the sharpest, at the cost of some ringing.
+var Lanczos = Filter{
+	Support: 3,
+	Kernel: func(x float64) float64 {
+		x = math.Abs(x)
+		if x == 0 {
+			return 1
+		}
+		if x < 3 {
+			px := math.Pi * x
+			return 3 * math.Sin(px) * math.Sin(px/3) / (px * px)
+		}
+		return 0
+	},
+}
+
+var (
+	toLinearOnce sync.Once
+	toLinearLUT  []float64
+)
+
+// toLinear converts a 16-bit sRGB-encoded value to linear light.
+func toLinear(v uint32) float64 {
+	toLinearOnce.Do(func() {
+		toLinearLUT = make([]float64, 0x10000)
+		for i := range toLinearLUT {
+			c := float64(i) / 0xffff
+			if c <= 0.04045 {
+				toLinearLUT[i] = c / 12.92
+			} else {
+				toLinearLUT[i] = math.Pow((c+0.055)/1.055, 2.4)
+			}
+		}
+	})
+	return toLinearLUT[v]
+}
+
+// toSRGB converts a linear light value to sRGB encoding.
+func toSRGB(c float64) float64 {
+	if c <= 0.0031308 {
+		return c * 12.92
+	}
+	return 1.055*math.Pow(c, 1/2.4) - 0.055
+}
+
+// pixels holds premultiplied RGBA values in the [0, 1] range.
+type pixels struct {
+	w, h   int
+	pix    []float64
+	linear bool
+}
+
+func load(img image.Image, linear bool) *pixels {
+	r := img.Bounds()
+	p := &pixels{
+		w:      r.Dx(),
+		h:      r.Dy(),
+		pix:    make([]float64, 4*r.Dx()*r.Dy()),
+		linear: linear,
+	}
+
+	idx := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			a := float64(c.A) / 0xffff
+			if linear {
+				p.pix[idx] = toLinear(uint32(c.R)) * a
+				p.pix[idx+1] = toLinear(uint32(c.G)) * a
+				p.pix[idx+2] = toLinear(uint32(c.B)) * a
+			} else {
+				p.pix[idx] = float64(c.R) / 0xffff * a
+				p.pix[idx+1] = float64(c.G) / 0xffff * a
+				p.pix[idx+2] = float64(c.B) / 0xffff * a
+			}
+			p.pix[idx+3] = a
+			idx += 4
+		}
+	}
+	return p
+}
+
+type contrib struct {
+	first   int
+	weights []float64
+}
+
+// contribs computes, for each destination coordinate, the source samples weights.
+func contribs(src, dst int, f Filter) []contrib {
+	scale := float64(src) / float64(dst)
+	fscale := math.Max(scale, 1) // widen the kernel when downsampling
+	support := f.Support * fscale
+
+	res := make([]contrib, dst)
+	for i := range res {
+		center := (float64(i)+0.5)*scale - 0.5
+		first := int(math.Ceil(center - support))
+		last := int(math.Floor(center + support))
+
+		weights := make([]float64, 0, last-first+1)
+		var sum float64
+		for j := first; j <= last; j++ {
+			w := f.Kernel((float64(j) - center) / fscale)
+			weights = append(weights, w)
+			sum += w
+		}
+		for j := range weights {
+			weights[j] /= sum
+		}
+		res[i] = contrib{first, weights}
+	}
+	return res
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// horizontal resamples rows to the provided width.
+func (p *pixels) horizontal(w int, f Filter) *pixels {
+	res := &pixels{w: w, h: p.h, pix: make([]float64, 4*w*p.h), linear: p.linear}
+	cs := contribs(p.w, w, f)
+	for y := 0; y < p.h; y++ {
+		row := p.pix[4*y*p.w:]
+		out := res.pix[4*y*w:]
+		for x, c := range cs {
+			for k, wt := range c.weights {
+				sx := clampInt(c.first+k, 0, p.w-1)
+				for ch := 0; ch < 4; ch++ {
+					out[4*x+ch] += row[4*sx+ch] * wt
+				}
+			}
+		}
+	}
+	return res
+}
+
+// vertical resamples columns to the provided height.
+func (p *pixels) vertical(h int, f Filter) *pixels {
+	res := &pixels{w: p.w, h: h, pix: make([]float64, 4*p.w*h), linear: p.linear}
+	cs := contribs(p.h, h, f)
+	for y, c := range cs {
+		out := res.pix[4*y*p.w:]
+		for k, wt := range c.weights {
+			sy := clampInt(c.first+k, 0, p.h-1)
+			row := p.pix[4*sy*p.w:]
+			for x := 0; x < 4*p.w; x++ {
+				out[x] += row[x] * wt
+			}
+		}
+	}
+	return res
+}
+
+// color returns the non-premultiplied, sRGB-encoded values of a pixel, in the [0, 1] range.
+func (p *pixels) color(idx int) (r, g, b, a float64) {
+	a = clamp(p.pix[idx+3])
+	if a == 0 {
+		return 0, 0, 0, 0
+	}
+	r, g, b = p.pix[idx]/a, p.pix[idx+1]/a, p.pix[idx+2]/a
+	if p.linear {
+		r, g, b = toSRGB(math.Max(0, r)), toSRGB(math.Max(0, g)), toSRGB(math.Max(0, b))
+	}
+	return clamp(r), clamp(g), clamp(b), a
+}
+
+func (p *pixels) nrgba() *image.NRGBA {
+	res := image.NewNRGBA(image.Rect(0, 0, p.w, p.h))
+	for idx := 0; idx < len(p.pix); idx += 4 {
+		r, g, b, a := p.color(idx)
+		res.Pix[idx] = uint8(r*0xff + 0.5)
+		res.Pix[idx+1] = uint8(g*0xff + 0.5)
+		res.Pix[idx+2] = uint8(b*0xff + 0.5)
+		res.Pix[idx+3] = uint8(a*0xff + 0.5)
+	}
+	return res
+}
+
+func (p *pixels) nrgba64() *image.NRGBA64 {
+	res := image.NewNRGBA64(image.Rect(0, 0, p.w, p.h))
+	for idx := 0; idx < len(p.pix); idx += 4 {
+		r, g, b, a := p.color(idx)
+		for ch, v := range []float64{r, g, b, a} {
+			n := uint16(v*0xffff + 0.5)
+			res.Pix[2*(idx+ch)] = uint8(n >> 8)
+			res.Pix[2*(idx+ch)+1] = uint8(n)
+		}
+	}
+	return res
+}
+
+// clamp restricts the value to the [0, 1] range.
+func clamp(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// Resize resamples the image to the provided size with the provided filter.
+// If linear is set, filtering happens on linear light values.
+func Resize(img image.Image, w, h int, f Filter, linear bool) *image.NRGBA {
+	return resize(img, w, h, f, linear).nrgba()
+}
+
+// Resize16 is like Resize, but keeps 16 bits per channel.
+func Resize16(img image.Image, w, h int, f Filter, linear bool) *image.NRGBA64 {
+	return resize(img, w, h, f, linear).nrgba64()
+}
+
+func resize(img image.Image, w, h int, f Filter, linear bool) *pixels {
+	p := load(img, linear)
+	if p.w == 0 || p.h == 0 || w <= 0 || h <= 0 {
+		return &pixels{w: w, h: h, pix: make([]float64, 4*w*h)}
+	}
+	return p.horizontal(w, f).vertical(h, f)
+}
+
+// Sharpen applies an unsharp mask of the provided amount to the image.
+// An amount of 0 leaves the image unchanged, 1 doubles the local contrast.
+func Sharpen(img image.Image, amount float64) *image.NRGBA {
+	return sharpen(img, amount).nrgba()
+}
+
+// Sharpen16 is like Sharpen, but keeps 16 bits per channel.
+func Sharpen16(img image.Image, amount float64) *image.NRGBA64 {
+	return sharpen(img, amount).nrgba64()
+}
+
+func sharpen(img image.Image, amount float64) *pixels {
+	p := load(img, true)
+	if amount == 0 || p.w == 0 || p.h == 0 {
+		return p
+	}
+
+	// 3x3 binomial blur, made of 2 separable passes.
+	blur := Filter{
+		Support: 1,
+		Kernel: func(x float64) float64 {
+			return 1 - math.Abs(x)/2
+		},
+	}
+	blurred := p.horizontal(p.w, blur).vertical(p.h, blur)
+
+	for idx := range p.pix {
+		p.pix[idx] += amount * (p.pix[idx] - blurred.pix[idx])
+	}
+	return p
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resample_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"yrh.dev/icns/internal/resample"
+)
+
+func TestResize(t *testing.T) {
+	red := color.NRGBA{0xff, 0, 0, 0xff}
+
+	// left half is opaque red, right half is transparent green.
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if x < 32 {
+				src.SetNRGBA(x, y, red)
+			} else {
+				src.SetNRGBA(x, y, color.NRGBA{0, 0xff, 0, 0})
+			}
+		}
+	}
+
+	for _, size := range []int{16, 48, 100} {
+		dst := resample.Resize(src, size, size, resample.CatmullRom, true)
+		if dst.Bounds().Dx() != size || dst.Bounds().Dy() != size {
+			t.Errorf("unexpected size: got %v, want %dx%d", dst.Bounds().Size(), size, size)
+		}
+
+		for x := 0; x < size; x++ {
+			c := dst.NRGBAAt(x, size/2)
+			// the transparent color must not bleed into partially transparent pixels.
+			if c.A != 0 && (c.R != 0xff || c.G != 0) {
+				t.Errorf("%dpx: unexpected color at x=%d: %v", size, x, c)
+			}
+		}
+		if c := dst.NRGBAAt(0, 0); c != red {
+			t.Errorf("%dpx: unexpected corner color: got %v, want %v", size, c, red)
+		}
+	}
+}
+
+func TestResize16(t *testing.T) {
+	// a flat color that 8 bits per channel can't represent.
+	want := color.NRGBA64{0x1234, 0x5678, 0x9abc, 0xffff}
+	src := image.NewNRGBA64(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			src.SetNRGBA64(x, y, want)
+		}
+	}
+
+	dst := resample.Resize16(src, 16, 16, resample.CatmullRom, true)
+	for _, p := range []image.Point{{0, 0}, {8, 8}, {15, 15}} {
+		c := dst.NRGBA64At(p.X, p.Y)
+		for _, d := range []int{int(c.R) - int(want.R), int(c.G) - int(want.G), int(c.B) - int(want.B)} {
+			if d < -2 || d > 2 {
+				t.Fatalf("unexpected color at %v: got %v, want %v", p, c, want)
+			}
+		}
+	}
+}
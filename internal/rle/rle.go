@@ -94,13 +94,18 @@ func Encode(b []byte) []byte {
 			}
 		} else {
 			flush() // write the tmp buffer before entering a repetition
-			for r.n > 0 {
+			for r.n >= 3 {
 				// because we only compress sequences of 3+ characters
 				// we encode repetitions of 3 to 130 as 0x80 to 0xff
 				n := utils.Min(r.n, 130)
 				res = append(res, byte(0x80+n-3), r.b)
 				r.n -= n
 			}
+			// what's left is too short for a repetition.
+			n = r.n
+			for i := 0; i < r.n; i++ {
+				tmp = append(tmp, r.b)
+			}
 		}
 	}
 	flush() // flush whatever we might have left in tmp
@@ -108,6 +113,7 @@ func Encode(b []byte) []byte {
 }
 
 // Decode RLE-decodes the provided bytes.
+// Decoding stops at the first truncated segment.
 func Decode(p []byte) []byte {
 	var res []byte
 	pos := 0
@@ -120,9 +126,15 @@ func Decode(p []byte) []byte {
 		b := p[pos]
 		if b < 0x80 {
 			n := int(b) + 1
+			if pos+1+n > len(p) {
+				break
+			}
 			res = append(res, p[pos+1:pos+1+n]...)
 			pos += 1 + n
 		} else {
+			if pos+1 >= len(p) {
+				break
+			}
 			x := p[pos+1]
 			n := int(b-0x80) + 3
 			for i := 0; i < n; i++ {
@@ -56,6 +56,14 @@ func TestStableRLE(t *testing.T) {
 				0xa5, 0x00, // 40* 0
 			},
 		},
+		{
+			"repetition remainder",
+			make([]byte, 132),
+			[]byte{
+				0xff, 0x00, // 130* 0
+				0x01, 0x00, 0x00, // 0, 0
+			},
+		},
 		{
 			"non repetitive",
 			[]byte{ // non-repetitive sequence of 130 bytes
@@ -15,17 +15,36 @@
 package utils
 
 import (
+	"bytes"
 	"image"
+	"image/color"
 	"image/draw"
 )
 
 func Img2NRGBA(img image.Image) *image.NRGBA {
 	r := img.Bounds()
 	res := image.NewNRGBA(r)
-	draw.Draw(res, r, img, image.Point{}, draw.Over)
+	// use Src so that the color of transparent pixels is preserved.
+	draw.Draw(res, r, img, r.Min, draw.Src)
 	return res
 }
 
+func Img2NRGBA64(img image.Image) *image.NRGBA64 {
+	r := img.Bounds()
+	res := image.NewNRGBA64(r)
+	draw.Draw(res, r, img, r.Min, draw.Src)
+	return res
+}
+
+// Is16Bit reports whether the image stores more than 8 bits per channel.
+func Is16Bit(img image.Image) bool {
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16, *image.Alpha16:
+		return true
+	}
+	return false
+}
+
 func NRGBAChannel(img *image.NRGBA, c int) []byte {
 	size := len(img.Pix) / 4
 	res := make([]byte, size)
@@ -35,3 +54,28 @@ func NRGBAChannel(img *image.NRGBA, c int) []byte {
 	}
 	return res
 }
+
+// SamePixels reports whether both images have the same bounds and non-premultiplied colors.
+func SamePixels(a, b image.Image) bool {
+	r := a.Bounds()
+	if r != b.Bounds() {
+		return false
+	}
+
+	if na, ok := a.(*image.NRGBA); ok {
+		if nb, ok := b.(*image.NRGBA); ok && na.Stride == nb.Stride {
+			return bytes.Equal(na.Pix, nb.Pix)
+		}
+	}
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			ca := color.NRGBA64Model.Convert(a.At(x, y))
+			cb := color.NRGBA64Model.Convert(b.At(x, y))
+			if ca != cb {
+				return false
+			}
+		}
+	}
+	return true
+}
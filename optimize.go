@@ -0,0 +1,143 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+
+	"yrh.dev/icns/internal/codec"
+	"yrh.dev/icns/internal/colorspace"
+	"yrh.dev/icns/internal/utils"
+)
+
+// candidate is a codec that can be used for a format, along with its encoding.
+type candidate struct {
+	codec    codec.Codec
+	encoding Encoding
+}
+
+// candidates lists the lossless codecs that can be used for the provided format.
+// PNG codecs tag their output with the provided color space chunks.
+func (i *ICNS) candidates(f *format, tags []colorspace.Chunk) []candidate {
+	var res []candidate
+	for _, e := range f.encodings {
+		if i.checkEncoding(f, e) != nil {
+			continue
+		}
+		switch e {
+		case EncodingPNG:
+			if f.custom {
+				res = append(res, candidate{f.codec, e})
+				break
+			}
+			res = append(res,
+				candidate{tagged(codec.ImageCodec, tags), e},
+				candidate{tagged(codec.PNGCodec(png.DefaultCompression), tags), e},
+				candidate{tagged(codec.PNGCodec(png.BestCompression), tags), e},
+			)
+		default:
+			if c := f.codecFor(e); c != nil {
+				res = append(res, candidate{c, e})
+			}
+		}
+	}
+	return res
+}
+
+// reference returns the image candidates must decode back to, keeping the
+// precision of 16-bit images.
+func reference(im image.Image) image.Image {
+	if utils.Is16Bit(im) {
+		return utils.Img2NRGBA64(im)
+	}
+	return utils.Img2NRGBA(im)
+}
+
+// encodeSmallest encodes the image with every candidate codec, concurrently within
+// the spare slots of the budget, and returns the smallest output that decodes back
+// to the same pixels. Only PNG candidates get the quantized image, if quantization
+// applies.
+func (i *ICNS) encodeSmallest(ctx context.Context, b budget, f *format, im image.Image, tags []colorspace.Chunk) (*bytes.Buffer, error) {
+	cands := i.candidates(f, tags)
+	if len(cands) == 0 {
+		return nil, fmt.Errorf("%w: no available encoding for format %s", ErrUnsupportedEncoding, f.code)
+	}
+
+	// nothing to compare against, and some codecs only store part of the
+	// channels anyway (e.g. pack needs a separate mask).
+	if len(cands) == 1 {
+		if cands[0].encoding == EncodingPNG {
+			im = i.quantize(f, im)
+		}
+		buf := new(bytes.Buffer)
+		if err := cands[0].codec.Encode(ctxWriter{ctx, buf}, im); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+
+	ref := reference(im)
+	pngRef := ref
+	if i.quantization != nil && f.accepts(EncodingPNG) {
+		pngRef = reference(i.quantize(f, im))
+	}
+
+	results := make([]*bytes.Buffer, len(cands))
+	errs := make([]error, len(cands))
+	b.run(len(cands), func(idx int) {
+		if ctx.Err() != nil {
+			return
+		}
+		c := cands[idx]
+		r := ref
+		if c.encoding == EncodingPNG {
+			r = pngRef
+		}
+		buf := new(bytes.Buffer)
+		if err := c.codec.Encode(ctxWriter{ctx, buf}, r); err != nil {
+			errs[idx] = err
+			return
+		}
+		dec, _, err := c.codec.Decode(bytes.NewReader(buf.Bytes()), f.res)
+		if err != nil || !utils.SamePixels(r, dec) {
+			return
+		}
+		results[idx] = buf
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var best *bytes.Buffer
+	for _, buf := range results {
+		if buf != nil && (best == nil || buf.Len() < best.Len()) {
+			best = buf
+		}
+	}
+
+	if best == nil {
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("%w: no lossless encoding for format %s", ErrUnsupportedEncoding, f.code)
+	}
+	return best, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"runtime"
+	"sync"
+)
+
+// WithParallelism sets the maximum number of codec operations that Decode and
+// Encode run at the same time, on different representations or, with
+// WithSmallestOutput, on the candidate encodings of the same one. It defaults to
+// GOMAXPROCS, and 1 disables parallel processing entirely. The output doesn't depend on that setting.
+func WithParallelism(n int) Option {
+	return func(i *ICNS) {
+		i.parallelism = n
+	}
+}
+
+// workers returns the size of the worker pool for codec work.
+func (i *ICNS) workers() int {
+	if i.parallelism > 0 {
+		return i.parallelism
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// budget is a number of worker slots shared between nested parallel work, so that
+// the total stays within the WithParallelism setting.
+type budget chan struct{}
+
+func newBudget(n int) budget {
+	return make(budget, n)
+}
+
+// acquire takes a slot, waiting for one to be available.
+func (b budget) acquire() {
+	b <- struct{}{}
+}
+
+// tryAcquire takes a slot if one is available right away. It always fails for a
+// nil budget.
+func (b budget) tryAcquire() bool {
+	select {
+	case b <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b budget) release() {
+	<-b
+}
+
+// run calls fn for every index in [0, n), from the calling goroutine, which is
+// expected to hold a slot already, and from extra goroutines for the spare slots
+// of the budget. Callers store results by index to keep a deterministic order.
+func (b budget) run(n int, fn func(idx int)) {
+	var wg sync.WaitGroup
+	for idx := 0; idx < n; idx++ {
+		if idx < n-1 && b.tryAcquire() {
+			wg.Add(1)
+			go func(idx int) {
+				defer wg.Done()
+				defer b.release()
+				fn(idx)
+			}(idx)
+			continue
+		}
+		fn(idx)
+	}
+	wg.Wait()
+}
+
+// parallel calls fn for every index in [0, n), from at most workers goroutines.
+// Callers store results by index to keep a deterministic order.
+func parallel(n, workers int, fn func(idx int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for idx := 0; idx < n; idx++ {
+			fn(idx)
+		}
+		return
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				fn(idx)
+			}
+		}()
+	}
+
+	for idx := 0; idx < n; idx++ {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParallelism(t *testing.T) {
+	t.Parallel()
+
+	var outputs [][]byte
+	var types [][]OSType
+	for _, n := range []int{1, 3, 16} {
+		icon, err := Decode(testdataFileReader(t, "mit.icns"), WithParallelism(n))
+		if err != nil {
+			t.Fatalf("Decode() with parallelism %d failed: %v", n, err)
+		}
+
+		var ts []OSType
+		for _, a := range icon.Assets() {
+			ts = append(ts, a.Type)
+		}
+		types = append(types, ts)
+
+		buf := new(bytes.Buffer)
+		if err := Encode(buf, icon); err != nil {
+			t.Fatalf("Encode() with parallelism %d failed: %v", n, err)
+		}
+		outputs = append(outputs, buf.Bytes())
+	}
+
+	for idx := 1; idx < len(outputs); idx++ {
+		if diff := cmp.Diff(types[0], types[idx]); diff != "" {
+			t.Errorf("Assets() mismatch (-want +got):\n%s", diff)
+		}
+		if !bytes.Equal(outputs[0], outputs[idx]) {
+			t.Errorf("Encode() output depends on parallelism")
+		}
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	t.Parallel()
+
+	icon := NewICNS(WithMinCompatibility(Leopard))
+	if err := icon.Add(testImage(32)); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 4; idx++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := icon.Add(testImage(16)); err != nil {
+				t.Error(err)
+			}
+			icon.RemoveResolution(Pixel16)
+		}()
+		go func() {
+			defer wg.Done()
+			if err := Encode(new(bytes.Buffer), icon); err != nil {
+				t.Error(err)
+			}
+			for _, a := range icon.Assets() {
+				_ = a.Image()
+			}
+			_ = icon.Info()
+			if _, err := icon.Render(Pixel64); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestBudget(t *testing.T) {
+	t.Parallel()
+
+	const slots = 3
+	b := newBudget(slots)
+	b.acquire()
+	defer b.release()
+
+	var mu sync.Mutex
+	running, max := 0, 0
+	calls := make([]int, 20)
+	b.run(len(calls), func(idx int) {
+		mu.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		calls[idx]++
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+
+	if max > slots {
+		t.Errorf("got %d concurrent calls, want at most %d", max, slots)
+	}
+	for idx, n := range calls {
+		if n != 1 {
+			t.Errorf("index %d processed %d times, want 1", idx, n)
+		}
+	}
+}
+
+func TestSmallestOutputParallelism(t *testing.T) {
+	t.Parallel()
+
+	var outputs [][]byte
+	for _, n := range []int{1, 8} {
+		icon := NewICNS(WithPreset(ModernAppPreset), WithSmallestOutput(), WithParallelism(n))
+		if err := icon.Add(testImage(32)); err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		if err := Encode(buf, icon); err != nil {
+			t.Fatalf("Encode() with parallelism %d failed: %v", n, err)
+		}
+		outputs = append(outputs, buf.Bytes())
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Error("Encode() output depends on parallelism")
+	}
+}
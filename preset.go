@@ -0,0 +1,188 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import "fmt"
+
+// Preset is a named icon profile for a common target, see WithPreset.
+type Preset int
+
+// Available presets.
+const (
+	// ModernAppPreset holds the sizes of an .iconset, 16 to 512 points at 1x and
+	// 2x, as PNG data, along with a table of contents.
+	ModernAppPreset Preset = iota
+	// LegacyAppPreset adds the legacy RLE representations and their masks to
+	// ModernAppPreset, for systems older than OS X Lion.
+	LegacyAppPreset
+	// DocumentPreset holds the same sizes as ModernAppPreset, but picks the
+	// smallest lossless encoding for each of them. It has no table of contents.
+	DocumentPreset
+	// MinimalPreset only holds the 128, 256, 512 and 1024 pixels slots (ic07 to
+	// ic10), as PNG data.
+	MinimalPreset
+	// IconutilPreset produces the same chunks as Apple's iconutil, in the same
+	// order: the 16 and 32 pixels images are stored as ARGB data in ic04 and ic05,
+	// the other ones as PNG data.
+	IconutilPreset
+)
+
+// presetSpec describes the settings of a preset.
+type presetSpec struct {
+	name string
+	// slots to fill, in the order Encode writes them.
+	slots     []OSType
+	encodings map[OSType]Encoding
+	toc       bool
+	smallest  bool
+}
+
+var (
+	iconsetSlots = []OSType{icp4, ic11, icp5, ic12, ic07, ic13, ic08, ic14, ic09, ic10}
+	legacySlots  = []OSType{is32, il32, ih32, it32}
+
+	presets = []presetSpec{
+		ModernAppPreset: {
+			name:  "modern app",
+			slots: iconsetSlots,
+			toc:   true,
+		},
+		LegacyAppPreset: {
+			name:  "app with legacy fallbacks",
+			slots: append(append([]OSType(nil), legacySlots...), iconsetSlots...),
+			toc:   true,
+		},
+		DocumentPreset: {
+			name:     "document icon",
+			slots:    iconsetSlots,
+			smallest: true,
+		},
+		MinimalPreset: {
+			name:  "minimal",
+			slots: []OSType{ic07, ic08, ic09, ic10},
+		},
+		IconutilPreset: {
+			name:  "iconutil",
+			slots: []OSType{ic12, ic07, ic13, ic08, ic04, ic14, ic09, ic05, ic10, ic11},
+			encodings: map[OSType]Encoding{
+				ic04: EncodingARGB,
+				ic05: EncodingARGB,
+			},
+		},
+	}
+)
+
+// String returns the name of the preset.
+func (p Preset) String() string {
+	if p >= 0 && int(p) < len(presets) {
+		return presets[p].name
+	}
+	return fmt.Sprintf("Preset(%d)", int(p))
+}
+
+// WithPreset restricts the slots filled by Add, ReplaceSize and FromImages to
+// those of the preset, and AddEncoded and Replace reject the other ones. Encode
+// writes them in a fixed order, so that the output doesn't depend on the way the
+// icon was built, followed by decoded chunks the preset doesn't list. It also sets the compatibility
+// range to that of these slots, along with the encodings and whether a table of
+// contents is written. Options that come after it can refine these settings.
+// Unknown presets are ignored.
+func WithPreset(p Preset) Option {
+	return func(i *ICNS) {
+		if p < 0 || int(p) >= len(presets) {
+			return
+		}
+		spec := presets[p]
+
+		i.slots = make(map[OSType]bool, len(spec.slots))
+		i.order = spec.slots
+		i.minCompat, i.maxCompat = Newest, Oldest
+		for _, t := range spec.slots {
+			i.slots[t] = true
+			f := imageFormats()[t]
+			if f.compat < i.minCompat {
+				i.minCompat = f.compat
+			}
+			if f.compat > i.maxCompat {
+				i.maxCompat = f.compat
+			}
+		}
+
+		i.typeEncodings = nil
+		for t, e := range spec.encodings {
+			if i.typeEncodings == nil {
+				i.typeEncodings = make(map[OSType]Encoding)
+			}
+			i.typeEncodings[t] = e
+		}
+		i.toc = spec.toc
+		i.smallest = spec.smallest
+	}
+}
+
+// WithTOC makes Encode write a table of contents, listing the type and size of
+// every other chunk, as Apple tools did up to macOS 10.15.
+func WithTOC() Option {
+	return func(i *ICNS) {
+		i.toc = true
+	}
+}
+
+// fills reports whether Add and similar methods should fill the slot for f.
+func (i *ICNS) fills(f *format) bool {
+	if f.compat < i.minCompat || f.compat > i.maxCompat {
+		return false
+	}
+	return i.slots == nil || i.slots[f.code]
+}
+
+// checkSlot returns an error if the icon doesn't fill the slot for f.
+func (i *ICNS) checkSlot(f *format) error {
+	if f.compat < i.minCompat || f.compat > i.maxCompat {
+		return fmt.Errorf("format %s: %w", f.code, ErrIncompatible)
+	}
+	if i.slots != nil && !i.slots[f.code] {
+		return fmt.Errorf("%w %s: not part of the preset", ErrUnsupportedType, f.code)
+	}
+	return nil
+}
+
+// output returns the representations written by Encode, in order. With a preset,
+// its slots come first, and the assets it doesn't list, such as decoded ones,
+// follow in their own order.
+func (i *ICNS) output() []*img {
+	if i.order == nil {
+		return i.assets
+	}
+
+	byType := make(map[OSType]*img, len(i.assets))
+	for _, a := range i.assets {
+		byType[a.format.code] = a
+	}
+	res := make([]*img, 0, len(i.assets))
+	listed := make(map[OSType]bool, len(i.order))
+	for _, t := range i.order {
+		listed[t] = true
+		if a := byType[t]; a != nil {
+			res = append(res, a)
+		}
+	}
+	for _, a := range i.assets {
+		if !listed[a.format.code] {
+			res = append(res, a)
+		}
+	}
+	return res
+}
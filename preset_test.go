@@ -0,0 +1,187 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// chunkTypes lists the chunks of an encoded icon, and checks its table of
+// contents if any.
+func chunkTypes(t *testing.T, data []byte) []OSType {
+	t.Helper()
+
+	var res []OSType
+	var entries []byte
+	for off := 8; off < len(data); {
+		code := OSType(binary.BigEndian.Uint32(data[off:]))
+		size := int(binary.BigEndian.Uint32(data[off+4:]))
+		if code == toc {
+			entries = data[off+8 : off+size]
+		} else {
+			if entries != nil {
+				if len(entries) < 8 || OSType(binary.BigEndian.Uint32(entries)) != code || int(binary.BigEndian.Uint32(entries[4:])) != size {
+					t.Fatalf("table of contents doesn't match chunk %s of size %d", code, size)
+				}
+				entries = entries[8:]
+			}
+			res = append(res, code)
+		}
+		off += size
+	}
+	if len(entries) != 0 {
+		t.Fatalf("table of contents lists %d missing chunks", len(entries)/8)
+	}
+	return res
+}
+
+func TestPresets(t *testing.T) {
+	t.Parallel()
+
+	iconset := []OSType{icp4, ic11, icp5, ic12, ic07, ic13, ic08, ic14, ic09, ic10}
+	tests := []struct {
+		preset Preset
+		want   []OSType
+		toc    bool
+	}{
+		{ModernAppPreset, iconset, true},
+		{LegacyAppPreset, append([]OSType{s8mk, is32, l8mk, il32, h8mk, ih32, t8mk, it32}, iconset...), true},
+		{DocumentPreset, iconset, false},
+		{MinimalPreset, []OSType{ic07, ic08, ic09, ic10}, false},
+		{IconutilPreset, []OSType{ic12, ic07, ic13, ic08, ic04, ic14, ic09, ic05, ic10, ic11}, false},
+	}
+
+	// a blank image, as only the layout matters here.
+	master := image.NewNRGBA(image.Rect(0, 0, 1024, 1024))
+	for _, tt := range tests {
+		icon, err := FromImage(master, WithPreset(tt.preset))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.preset, err)
+		}
+		buf := new(bytes.Buffer)
+		if err := Encode(buf, icon); err != nil {
+			t.Fatalf("%s: %v", tt.preset, err)
+		}
+
+		if diff := cmp.Diff(tt.want, chunkTypes(t, buf.Bytes())); diff != "" {
+			t.Errorf("%s: chunks mismatch (-want +got):\n%s", tt.preset, diff)
+		}
+		if got := bytes.Contains(buf.Bytes()[8:16], []byte("TOC ")); got != tt.toc {
+			t.Errorf("%s: table of contents written: %v, want %v", tt.preset, got, tt.toc)
+		}
+
+		// decoding keeps the table of contents.
+		got, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.preset, err)
+		}
+		if got.toc != tt.toc || len(got.unsupported) != 0 {
+			t.Errorf("%s: unexpected decoded table of contents: %v, unsupported chunks %v", tt.preset, got.toc, got.unsupported)
+		}
+	}
+
+	icon, err := Decode(testdataFileReader(t, "mit.icns"), WithPreset(IconutilPreset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range icon.Assets() {
+		if (a.Type == ic04 || a.Type == ic05) != (a.Encoding == EncodingARGB) {
+			t.Errorf("%s: unexpected encoding %s", a.Type, a.Encoding)
+		}
+	}
+}
+
+func TestPresetUnlisted(t *testing.T) {
+	t.Parallel()
+
+	pngData := new(bytes.Buffer)
+	if err := png.Encode(pngData, testImage(16)); err != nil {
+		t.Fatal(err)
+	}
+	icon := NewICNS(WithPreset(MinimalPreset))
+	if err := icon.AddEncoded(icp4, pngData.Bytes()); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("AddEncoded(icp4) error = %v, want %v", err, ErrUnsupportedType)
+	}
+	if err := icon.Replace(icp4, testImage(16)); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Replace(icp4) error = %v, want %v", err, ErrUnsupportedType)
+	}
+	if len(icon.Assets()) != 0 {
+		t.Errorf("unexpected assets %v", icon.Assets())
+	}
+
+	// decoded chunks the preset doesn't list are written after its slots.
+	plain, err := Decode(testdataFileReader(t, "mit.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, plain); err != nil {
+		t.Fatal(err)
+	}
+	want := chunkTypes(t, buf.Bytes())
+
+	icon, err = Decode(testdataFileReader(t, "mit.icns"), WithPreset(MinimalPreset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := Encode(buf, icon); err != nil {
+		t.Fatal(err)
+	}
+	got := chunkTypes(t, buf.Bytes())
+	if len(got) != len(want) {
+		t.Errorf("Encode() wrote chunks %v, want all of %v", got, want)
+	}
+}
+
+func TestTOCStream(t *testing.T) {
+	t.Parallel()
+
+	icon, err := FromImage(testImage(256), WithPreset(LegacyAppPreset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, icon); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "toc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := icon.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, buf.Bytes()) {
+		t.Error("streamed output differs from the buffered one")
+	}
+	chunkTypes(t, got)
+}
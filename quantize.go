@@ -0,0 +1,71 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"image"
+
+	"yrh.dev/icns/internal/quantize"
+	"yrh.dev/icns/internal/utils"
+)
+
+// Quantization configures the lossy palette reduction of PNG images.
+type Quantization struct {
+	// MaxError is the maximum root mean square error allowed for a quantized image,
+	// in 8-bit alpha-premultiplied units. Images that can't be quantized within
+	// that threshold are encoded losslessly.
+	MaxError float64
+	// Colors is the maximum palette size, up to (and defaulting to) 256.
+	Colors int
+	// Dither enables Floyd-Steinberg error diffusion.
+	Dither bool
+	// Report, if set, is called for each quantized slot with the resulting error,
+	// and whether the quantized image was kept. It may be called concurrently for
+	// different slots, see WithParallelism.
+	Report func(t OSType, err float64, applied bool)
+}
+
+// WithQuantization enables lossy palette quantization for the PNG images written by Encode.
+// 16-bit images are never quantized.
+func WithQuantization(q Quantization) Option {
+	return func(i *ICNS) {
+		i.quantization = &q
+	}
+}
+
+// quantize applies the icon quantization settings to an image destined to the provided format.
+// 16-bit images are left alone, as palettes only hold 8-bit colors.
+func (i *ICNS) quantize(f *format, im image.Image) image.Image {
+	q := i.quantization
+	if q == nil || utils.Is16Bit(im) {
+		return im
+	}
+
+	n := q.Colors
+	if n <= 0 || n > 256 {
+		n = 256
+	}
+
+	res, e := quantize.Quantize(utils.Img2NRGBA(im), n, q.Dither)
+	applied := e <= q.MaxError
+	if q.Report != nil {
+		q.Report(f.code, e, applied)
+	}
+
+	if !applied {
+		return im
+	}
+	return res
+}
@@ -15,116 +15,247 @@
 package icns
 
 import (
+	"context"
 	"fmt"
 	"image"
-	"image/draw"
+	"image/color"
 	"io"
 	"io/ioutil"
+	"time"
 
 	"yrh.dev/icns/internal/binary"
+	"yrh.dev/icns/internal/codec"
+	"yrh.dev/icns/internal/colorspace"
+	"yrh.dev/icns/internal/utils"
 )
 
-func readICNS(r binary.Reader, metaOnly bool) (*ICNS, error) {
-	hdr := r.Uint32()
+// rawChunk is a chunk located in the input, along with its decoding result.
+type rawChunk struct {
+	code   OSType
+	offset int64
+	data   *binary.Reader
+	size   int
+
+	image   image.Image
+	encoder string
+	err     error
+	// raw holds the payload of JPEG 2000 data, which can't be decoded.
+	raw []byte
+	// color space chunks of PNG data.
+	color []colorspace.Chunk
+}
+
+// readICNS parses the icon in r, using the settings of cfg for the decoding work.
+func readICNS(ctx context.Context, r binary.Reader, metaOnly bool, cfg *ICNS) (*ICNS, error) {
+	total := len(r)
+	if total < 8 {
+		return nil, fmt.Errorf("%w: truncated header", ErrFormat)
+	}
+
+	hdr := OSType(r.Uint32())
 	if hdr != magic {
-		return nil, fmt.Errorf("wrong magic number for ICNS file: %x", hdr)
+		return nil, fmt.Errorf("%w: wrong magic number for ICNS file: %x", ErrFormat, hdr)
 	}
 
 	_ = r.Uint32() // size
 
-	minCompat := Newest
-	maxCompat := Oldest
-
-	var assets []*img
-	masks := make(map[uint32]image.Image)
-
-	var unsupportedCodes []uint32
+	// locate all the chunks first, so that the structure is validated before any
+	// decoding happens.
+	var chunks []*rawChunk
 	for {
 		if len(r) == 0 {
 			break
 		}
 
-		code := r.Uint32()
+		offset := int64(total - len(r))
+		if len(r) < 8 {
+			return nil, &ChunkError{Offset: offset, Err: fmt.Errorf("%w: truncated chunk header", ErrFormat)}
+		}
+
+		code := OSType(r.Uint32())
 		size := int(r.Uint32())
-		sub := r.Section(size - 8) // size value includes both uint32 for code and size
+		// size value includes both uint32 for code and size
+		if size < 8 || size-8 > len(r) {
+			return nil, &ChunkError{Type: code, Offset: offset, Err: fmt.Errorf("%w: invalid chunk size %d", ErrFormat, size)}
+		}
+		chunks = append(chunks, &rawChunk{
+			code:   code,
+			offset: offset,
+			data:   r.Section(size - 8),
+			size:   size - 8,
+		})
+	}
 
-		if f, ok := supportedMaskFormats[code]; ok {
-			if metaOnly {
-				continue
+	if !metaOnly {
+		var jobs []*rawChunk
+		for _, c := range chunks {
+			if formatFor(c.code) != nil {
+				jobs = append(jobs, c)
 			}
+		}
 
-			i, _, err := f.codec.Decode(sub, f.res)
-			if err != nil {
-				continue
+		t := cfg.tracker(DecodeOperation, len(jobs))
+		parallel(len(jobs), cfg.workers(), func(idx int) {
+			if ctx.Err() != nil {
+				return
 			}
-
-			if f.compat < minCompat {
-				minCompat = f.compat
+			c := jobs[idx]
+			f := formatFor(c.code)
+			start := time.Now()
+			data := []byte(*c.data)
+			c.image, c.encoder, c.err = f.codec.Decode(ctxReader{ctx, c.data}, f.res)
+			// keep JPEG 2000 data as if attached with AddEncoded.
+			if c.err != nil && f.accepts(EncodingJPEG2000) {
+				if enc, _, _, err := codec.Sniff(data); err == nil && enc == string(EncodingJPEG2000) {
+					c.raw, c.encoder, c.err = data, enc, nil
+				}
 			}
-
-			if f.compat > maxCompat {
-				maxCompat = f.compat
+			if c.encoder == string(EncodingPNG) {
+				c.color = colorspace.Read(data)
 			}
+			t.report(c.code, c.size, start)
+		})
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
 
-			masks[code] = i
+	var assets []*img
+	masks := make(map[OSType]*rawChunk)
+	for _, c := range chunks {
+		if _, ok := maskFormats()[c.code]; ok && c.err == nil {
+			masks[c.code] = c
+		}
+	}
 
+	var unsupported, failed []*chunk
+	var hasTOC bool
+	for _, c := range chunks {
+		if _, ok := maskFormats()[c.code]; ok {
+			if metaOnly {
+				continue
+			}
+
+			if c.err != nil {
+				failed = append(failed, &chunk{code: c.code, size: c.size, err: &ChunkError{Type: c.code, Offset: c.offset, Err: c.err}})
+			}
 			continue
 		}
 
-		if f, ok := supportedImageFormats[code]; ok {
+		if f, ok := imageFormats()[c.code]; ok {
 			asset := &img{
 				format: f,
+				size:   c.size,
 			}
 
-			if !metaOnly {
-				i, enc, err := f.codec.Decode(sub, f.res)
-				if err != nil {
+			if metaOnly {
+				// kept for DecodeConfig to report the color model.
+				asset.raw = *c.data
+			} else {
+				if c.err != nil {
+					failed = append(failed, &chunk{code: c.code, size: c.size, err: &ChunkError{Type: c.code, Offset: c.offset, Err: c.err}})
 					continue
 				}
 
-				// TODO: don't assume the mask is parsed first
-				if m := masks[f.combineCode]; m != nil {
-					r := image.Rect(0, 0, int(f.res), int(f.res))
-
-					c := image.NewRGBA(r)
+				if c.raw != nil {
+					asset.raw = c.raw
+					asset.encoder = Encoding(c.encoder)
+					assets = append(assets, asset)
+					continue
+				}
 
-					draw.DrawMask(c, r, i, image.Pt(0, 0), m, image.Pt(0, 0), draw.Over)
-					i = c
+				i := c.image
+				if m := masks[f.combineCode]; m != nil {
+					i = applyMask(i, m.image)
+					asset.maskSize = m.size
 				}
 
 				asset.Image = i
-				asset.encoder = enc
+				asset.encoder = Encoding(c.encoder)
+				asset.color = c.color
 			}
 
 			assets = append(assets, asset)
+			continue
+		}
 
-			if f.compat < minCompat {
-				minCompat = f.compat
-			}
-
-			if f.compat > maxCompat {
-				maxCompat = f.compat
-			}
-
+		// the table of contents is rebuilt by Encode.
+		if c.code == toc {
+			hasTOC = true
 			continue
 		}
 
-		unsupportedCodes = append(unsupportedCodes, code)
+		unsupported = append(unsupported, &chunk{code: c.code, size: c.size})
 	}
 
-	return &ICNS{
-		minCompat:        minCompat,
-		maxCompat:        maxCompat,
-		assets:           assets,
-		unsupportedCodes: unsupportedCodes,
-	}, nil
+	i := &ICNS{
+		minCompat:     Oldest,
+		maxCompat:     Newest,
+		derivedCompat: true,
+		assets:        assets,
+		unsupported:   unsupported,
+		failed:        failed,
+		toc:           hasTOC,
+	}
+	i.deriveCompat()
+	return i, nil
+}
+
+// applyMask combines the color channels of a legacy image with its mask. The mask
+// values are used as is for the alpha channel of the non-premultiplied result, so
+// that encoding it again produces the same data.
+func applyMask(im, mask image.Image) *image.NRGBA {
+	res := utils.Img2NRGBA(im)
+	r := res.Rect.Intersect(mask.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			var a uint8
+			if m, ok := mask.(*image.Alpha); ok {
+				a = m.AlphaAt(x, y).A
+			} else {
+				a = color.AlphaModel.Convert(mask.At(x, y)).(color.Alpha).A
+			}
+			res.Pix[res.PixOffset(x, y)+3] = a
+		}
+	}
+	return res
+}
+
+// formatFor returns the image or mask format for the provided type, if supported.
+func formatFor(t OSType) *format {
+	if f, ok := maskFormats()[t]; ok {
+		return f
+	}
+	return imageFormats()[t]
 }
 
 // Decode loads a .icns file from the provided reader.
-func Decode(r io.Reader) (*ICNS, error) {
-	bytes, err := ioutil.ReadAll(r)
+// Options are applied to the decoded icon: they can tune the decoding itself (see
+// WithParallelism and WithProgress), and the policy used when encoding it again.
+// Compatibility options override the range derived from the icon content.
+func Decode(r io.Reader, opts ...Option) (*ICNS, error) {
+	return DecodeContext(context.Background(), r, opts...)
+}
+
+// DecodeContext is like Decode, but stops as soon as possible when the context is
+// done, returning the context error.
+func DecodeContext(ctx context.Context, r io.Reader, opts ...Option) (*ICNS, error) {
+	bytes, err := ioutil.ReadAll(ctxReader{ctx, r})
 	if err != nil {
 		return nil, err
 	}
-	return readICNS(bytes, false)
+
+	i, err := readICNS(ctx, bytes, false, NewICNS(opts...))
+	if err != nil {
+		return nil, err
+	}
+
+	minCompat, maxCompat := i.minCompat, i.maxCompat
+	for _, o := range opts {
+		o(i)
+	}
+	if i.minCompat != minCompat || i.maxCompat != maxCompat {
+		i.derivedCompat = false
+	}
+	return i, nil
 }
@@ -0,0 +1,166 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"yrh.dev/icns/internal/codec"
+)
+
+// Codec reads and writes chunk payloads. Decode returns the image along with the
+// name of the encoding found in the data. The resolution passed to Decode is the
+// one of the chunk type.
+type Codec = codec.Codec
+
+// FormatSpec describes a chunk type to register with Register.
+type FormatSpec struct {
+	// Type identifies the chunk.
+	Type OSType
+	// Resolution is the size of the image in pixels.
+	Resolution Resolution
+	// Scale is the display scale the image is designed for. It defaults to 1.
+	Scale int
+	// Compatibility is the first OS version able to read that chunk type.
+	Compatibility Compatibility
+	// Encoding names the payload encoding written by Codec.
+	Encoding Encoding
+	Codec    Codec
+
+	// Mask optionally identifies a separate chunk holding the alpha channel, like
+	// legacy formats do. MaskCodec then encodes the alpha channel of the image, and
+	// decodes to an image whose alpha channel is the mask.
+	Mask      OSType
+	MaskCodec Codec
+}
+
+// registryMu guards the format maps. They are never modified once published,
+// Register replaces them instead.
+var registryMu sync.RWMutex
+
+// imageFormats returns the supported image formats. The map must not be modified.
+func imageFormats() map[OSType]*format {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return supportedImageFormats
+}
+
+// sortedImageFormats returns the supported image formats, sorted by resolution,
+// scale and type, so that slots are filled in a deterministic order.
+func sortedImageFormats() []*format {
+	formats := imageFormats()
+	res := make([]*format, 0, len(formats))
+	for _, f := range formats {
+		res = append(res, f)
+	}
+	sort.Slice(res, func(x, y int) bool {
+		if res[x].res != res[y].res {
+			return res[x].res < res[y].res
+		}
+		if res[x].scale != res[y].scale {
+			return res[x].scale < res[y].scale
+		}
+		return res[x].code < res[y].code
+	})
+	return res
+}
+
+// maskFormats returns the supported mask formats. The map must not be modified.
+func maskFormats() map[OSType]*format {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return supportedMaskFormats
+}
+
+// reserved reports whether t can't be registered: the file header and the table of
+// contents are written by Encode itself.
+func reserved(t OSType) bool {
+	return t == 0 || t == magic || t == toc
+}
+
+// Register adds support for a new chunk type. Registered types are decoded by
+// Decode, filled by Add and the other image setters, written by Encode and
+// described by Summary, like the built-in ones.
+// Register is meant to be called from init functions; it is safe for concurrent
+// use, but icons don't pick up types registered after their creation consistently.
+func Register(spec FormatSpec) error {
+	if spec.Scale == 0 {
+		spec.Scale = 1
+	}
+	switch {
+	case reserved(spec.Type):
+		return fmt.Errorf("%w: invalid type %s", ErrInvalidSpec, spec.Type)
+	case spec.Resolution == 0 || spec.Scale < 0 || int(spec.Resolution)%spec.Scale != 0:
+		return fmt.Errorf("%w: invalid size %d@%dx for %s", ErrInvalidSpec, spec.Resolution, spec.Scale, spec.Type)
+	case spec.Codec == nil || spec.Encoding == "":
+		return fmt.Errorf("%w: missing codec or encoding for %s", ErrInvalidSpec, spec.Type)
+	case spec.Mask != 0 && (spec.MaskCodec == nil || spec.Mask == spec.Type || reserved(spec.Mask)):
+		return fmt.Errorf("%w: invalid mask %s for %s", ErrInvalidSpec, spec.Mask, spec.Type)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for _, t := range []OSType{spec.Type, spec.Mask} {
+		if t == 0 {
+			continue
+		}
+		if _, ok := supportedImageFormats[t]; ok {
+			return fmt.Errorf("%w: %s", ErrRegistered, t)
+		}
+		if _, ok := supportedMaskFormats[t]; ok {
+			return fmt.Errorf("%w: %s", ErrRegistered, t)
+		}
+	}
+
+	images := make(map[OSType]*format, len(supportedImageFormats)+1)
+	for t, f := range supportedImageFormats {
+		images[t] = f
+	}
+	images[spec.Type] = &format{
+		code:        spec.Type,
+		combineCode: spec.Mask,
+		res:         spec.Resolution,
+		scale:       spec.Scale,
+		compat:      spec.Compatibility,
+		codec:       spec.Codec,
+		encodings:   []Encoding{spec.Encoding},
+		custom:      true,
+	}
+
+	masks := supportedMaskFormats
+	if spec.Mask != 0 {
+		masks = make(map[OSType]*format, len(supportedMaskFormats)+1)
+		for t, f := range supportedMaskFormats {
+			masks[t] = f
+		}
+		masks[spec.Mask] = &format{
+			code:        spec.Mask,
+			combineCode: spec.Type,
+			res:         spec.Resolution,
+			scale:       spec.Scale,
+			compat:      spec.Compatibility,
+			codec:       spec.MaskCodec,
+			custom:      true,
+		}
+	}
+
+	supportedImageFormats, supportedMaskFormats = images, masks
+	return nil
+}
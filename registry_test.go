@@ -0,0 +1,148 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"yrh.dev/icns/internal/codec"
+	"yrh.dev/icns/internal/utils"
+)
+
+// rawCodec stores NRGBA pixels as is, after a "RAW" header.
+type rawCodec struct{}
+
+func (rawCodec) Encode(w io.Writer, im image.Image) error {
+	n := utils.Img2NRGBA(im)
+	if _, err := w.Write([]byte("RAW")); err != nil {
+		return err
+	}
+	_, err := w.Write(n.Pix)
+	return err
+}
+
+func (rawCodec) Decode(r io.Reader, res Resolution) (image.Image, string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	n := image.NewNRGBA(image.Rect(0, 0, int(res), int(res)))
+	if !bytes.HasPrefix(data, []byte("RAW")) || len(data)-3 != len(n.Pix) {
+		return nil, "", ErrCorrupt
+	}
+	copy(n.Pix, data[3:])
+	return n, "raw", nil
+}
+
+// unregister drops registered types, for test isolation.
+func unregister(types ...OSType) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	images := make(map[OSType]*format)
+	for t, f := range supportedImageFormats {
+		images[t] = f
+	}
+	masks := make(map[OSType]*format)
+	for t, f := range supportedMaskFormats {
+		masks[t] = f
+	}
+	for _, t := range types {
+		delete(images, t)
+		delete(masks, t)
+	}
+	supportedImageFormats, supportedMaskFormats = images, masks
+}
+
+// TestRegister isn't parallel, so that registered types don't leak into other tests.
+func TestRegister(t *testing.T) {
+	raw, _ := ParseOSType("raw1")
+	paired, _ := ParseOSType("raw2")
+	mask, _ := ParseOSType("rmk2")
+	defer unregister(raw, paired, mask)
+
+	for _, spec := range []FormatSpec{
+		{Type: raw, Resolution: 48, Compatibility: Lion, Encoding: "raw", Codec: rawCodec{}},
+		{Type: paired, Resolution: 40, Compatibility: Lion, Encoding: "raw", Codec: rawCodec{}, Mask: mask, MaskCodec: codec.MaskCodec},
+	} {
+		if err := Register(spec); err != nil {
+			t.Fatalf("Register(%s) failed: %v", spec.Type, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		spec FormatSpec
+		want error
+	}{
+		{"duplicate", FormatSpec{Type: raw, Resolution: 48, Encoding: "raw", Codec: rawCodec{}}, ErrRegistered},
+		{"builtin", FormatSpec{Type: ic07, Resolution: 128, Encoding: "raw", Codec: rawCodec{}}, ErrRegistered},
+		{"file header", FormatSpec{Type: magic, Resolution: 48, Encoding: "raw", Codec: rawCodec{}}, ErrInvalidSpec},
+		{"table of contents", FormatSpec{Type: toc, Resolution: 48, Encoding: "raw", Codec: rawCodec{}}, ErrInvalidSpec},
+		{"table of contents mask", FormatSpec{Type: OSType(1), Resolution: 48, Encoding: "raw", Codec: rawCodec{}, Mask: toc, MaskCodec: rawCodec{}}, ErrInvalidSpec},
+		{"no codec", FormatSpec{Type: OSType(1), Resolution: 48, Encoding: "raw"}, ErrInvalidSpec},
+		{"no size", FormatSpec{Type: OSType(1), Encoding: "raw", Codec: rawCodec{}}, ErrInvalidSpec},
+		{"no mask codec", FormatSpec{Type: OSType(1), Resolution: 48, Encoding: "raw", Codec: rawCodec{}, Mask: OSType(2)}, ErrInvalidSpec},
+	} {
+		if err := Register(tt.spec); !errors.Is(err, tt.want) {
+			t.Errorf("Register(%s) returned %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	icon := NewICNS(WithMinCompatibility(Lion))
+	src48, src40 := testImage(48), testImage(40)
+	for _, im := range []image.Image{src48, src40} {
+		if err := icon.Add(im); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, icon); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var types []OSType
+	for _, c := range got.Summary().Chunks {
+		if !c.Supported {
+			t.Errorf("%s: not supported after decoding", c.Type)
+		}
+		types = append(types, c.Type)
+	}
+	if diff := cmp.Diff([]OSType{mask, paired, raw}, types); diff != "" {
+		t.Errorf("Summary() mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, a := range got.Assets() {
+		if a.Type == raw {
+			if a.Encoding != "raw" {
+				t.Errorf("unexpected encoding for %s: got %s, want raw", a.Type, a.Encoding)
+			}
+			if !utils.SamePixels(src48, a.Image()) {
+				t.Errorf("%s: pixels changed by the round trip", a.Type)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"fmt"
+	"image"
+)
+
+// bestSource picks the representation to render the requested resolution from:
+// the smallest one that is at least as large, or else the largest one.
+// Among equivalent resolutions, the ones designed for the requested scale win.
+func (i *ICNS) bestSource(r Resolution, scale int) *img {
+	var best *img
+	better := func(a *img) bool {
+		if best == nil {
+			return true
+		}
+		ar, br := a.format.res, best.format.res
+		switch {
+		case ar == br:
+			return a.format.scale == scale && best.format.scale != scale
+		case ar >= r && br >= r:
+			return ar < br
+		case ar >= r:
+			return true
+		case br >= r:
+			return false
+		}
+		return ar > br
+	}
+
+	for _, a := range i.assets {
+		if a.image() == nil {
+			continue
+		}
+		if better(a) {
+			best = a
+		}
+	}
+	return best
+}
+
+// maxRender is the largest resolution Render accepts, twice the largest slot.
+const maxRender = 2 * Pixel1024
+
+// renderSize returns the resolution for the provided point size and scale.
+func renderSize(points, scale int) (Resolution, error) {
+	if points <= 0 || scale <= 0 || points > int(maxRender) || scale > int(maxRender) {
+		return 0, fmt.Errorf("%w: invalid size %d@%dx", ErrUnsupportedSize, points, scale)
+	}
+	return Resolution(points * scale), nil
+}
+
+func (i *ICNS) render(r Resolution, scale int) (image.Image, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if r == 0 {
+		return nil, &SizeError{}
+	}
+	if r > maxRender {
+		return nil, fmt.Errorf("%w: resolution %d is larger than %d", ErrUnsupportedSize, r, maxRender)
+	}
+
+	src := i.bestSource(r, scale)
+	if src == nil {
+		return nil, fmt.Errorf("%w available", ErrNoImage)
+	}
+
+	if src.format.res == r {
+		return src.image(), nil
+	}
+	return i.resize(src.image(), r), nil
+}
+
+func (i *ICNS) bestMatch(r Resolution, scale int) (Asset, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	src := i.bestSource(r, scale)
+	if src == nil {
+		return Asset{}, fmt.Errorf("%w available", ErrNoImage)
+	}
+
+	return i.asset(src), nil
+}
+
+// BestMatch returns the representation that Render would use for the provided resolution.
+func (i *ICNS) BestMatch(r Resolution) (Asset, error) {
+	return i.bestMatch(r, 1)
+}
+
+// BestMatchSize returns the representation that RenderSize would use for the
+// provided point size and scale.
+func (i *ICNS) BestMatchSize(points, scale int) (Asset, error) {
+	r, err := renderSize(points, scale)
+	if err != nil {
+		return Asset{}, err
+	}
+	return i.bestMatch(r, scale)
+}
+
+// Render returns the icon image at exactly the provided resolution, up to 2048
+// pixels. The image is resampled from the best available representation if needed:
+// the smallest one that is at least as large, or else the largest one. See
+// WithFilter for resampling options.
+func (i *ICNS) Render(r Resolution) (image.Image, error) {
+	return i.render(r, 1)
+}
+
+// RenderSize returns the icon image for the provided point size, at the provided
+// scale. Representations designed for that scale are preferred over others with the
+// same resolution.
+func (i *ICNS) RenderSize(points, scale int) (image.Image, error) {
+	r, err := renderSize(points, scale)
+	if err != nil {
+		return nil, err
+	}
+	return i.render(r, scale)
+}
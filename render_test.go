@@ -0,0 +1,105 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+	icon, err := Decode(testdataFileReader(t, "mit.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []struct {
+		name          string
+		points, scale int
+		source        OSType
+	}{
+		{"exact", 128, 1, ic07},
+		{"exact 2x", 16, 2, ic11},
+		{"same pixels 1x", 32, 1, ic05},
+		{"downsample", 100, 1, ic07},
+		{"downsample 2x", 100, 2, ic13},
+		{"upsample", 2048, 1, ic10},
+	}
+
+	for _, tt := range data {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			r := Resolution(tt.points * tt.scale)
+			img, err := icon.RenderSize(tt.points, tt.scale)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Bounds().Dx() != int(r) || img.Bounds().Dy() != int(r) {
+				t.Errorf("unexpected image size: got %v, want %dx%d", img.Bounds().Size(), r, r)
+			}
+
+			src := icon.bestSource(r, tt.scale)
+			if src.format.code != tt.source {
+				t.Errorf("unexpected source: got %s, want %s", src.format.code, tt.source)
+			}
+			if a, err := icon.BestMatchSize(tt.points, tt.scale); err != nil || a.Type != tt.source {
+				t.Errorf("BestMatchSize(%d, %d) = %s, %v, want %s", tt.points, tt.scale, a.Type, err, tt.source)
+			}
+		})
+	}
+}
+
+func TestRenderLimits(t *testing.T) {
+	t.Parallel()
+	icon := NewICNS()
+	if err := icon.Add(testImage(16)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := icon.Render(100000); !errors.Is(err, ErrUnsupportedSize) {
+		t.Errorf("Render(100000) error = %v, want %v", err, ErrUnsupportedSize)
+	}
+	if _, err := icon.RenderSize(1<<40, 2); !errors.Is(err, ErrUnsupportedSize) {
+		t.Errorf("RenderSize(1<<40, 2) error = %v, want %v", err, ErrUnsupportedSize)
+	}
+	if _, err := icon.BestMatchSize(0, 1); !errors.Is(err, ErrUnsupportedSize) {
+		t.Errorf("BestMatchSize(0, 1) error = %v, want %v", err, ErrUnsupportedSize)
+	}
+}
+
+func TestUndecodableSource(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, testImage(128)); err != nil {
+		t.Fatal(err)
+	}
+	// a valid header, but truncated image data.
+	data := buf.Bytes()[:64]
+
+	icon := NewICNS()
+	if err := icon.AddEncoded(ic07, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := icon.Render(128); !errors.Is(err, ErrNoImage) {
+		t.Errorf("Render(128) error = %v, want %v", err, ErrNoImage)
+	}
+	if a := icon.assets[0]; !a.undecodable {
+		t.Error("decoding failure was not recorded")
+	}
+}
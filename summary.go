@@ -0,0 +1,196 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"strings"
+)
+
+// Summary is a structured description of an icon, suitable for JSON marshaling.
+type Summary struct {
+	MinCompatibility Compatibility  `json:"minCompatibility"`
+	MaxCompatibility Compatibility  `json:"maxCompatibility"`
+	Chunks           []ChunkSummary `json:"chunks"`
+}
+
+// ChunkSummary describes a single chunk of an icon.
+type ChunkSummary struct {
+	Type OSType `json:"type"`
+	// Size is the size of the payload in bytes, or 0 if it hasn't been encoded yet.
+	Size int `json:"size"`
+	// Supported is false for chunk types this package doesn't know about.
+	Supported bool `json:"supported"`
+	// Mask is set for the separate alpha channel of legacy images.
+	Mask       bool     `json:"mask,omitempty"`
+	Encoding   Encoding `json:"encoding,omitempty"`
+	Width      int      `json:"width,omitempty"`
+	Height     int      `json:"height,omitempty"`
+	ColorModel string   `json:"colorModel,omitempty"`
+	ColorSpace string   `json:"colorSpace,omitempty"`
+	// Compatibility is unset for unsupported chunks.
+	Compatibility *Compatibility `json:"compatibility,omitempty"`
+	Scale         int            `json:"scale,omitempty"`
+	// Error is the decoding error, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// compatRef returns a reference to a copy of c.
+func compatRef(c Compatibility) *Compatibility {
+	return &c
+}
+
+// colorModelName returns a short name for the color model of the image.
+func colorModelName(im image.Image) string {
+	switch im.(type) {
+	case nil:
+		return ""
+	case *image.RGBA:
+		return "RGBA"
+	case *image.RGBA64:
+		return "RGBA64"
+	case *image.NRGBA:
+		return "NRGBA"
+	case *image.NRGBA64:
+		return "NRGBA64"
+	case *image.Alpha:
+		return "Alpha"
+	case *image.Alpha16:
+		return "Alpha16"
+	case *image.Gray:
+		return "Gray"
+	case *image.Gray16:
+		return "Gray16"
+	case *image.Paletted:
+		return "Paletted"
+	case *image.YCbCr:
+		return "YCbCr"
+	case *image.CMYK:
+		return "CMYK"
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", im), "*")
+}
+
+// Summary describes the content of the icon. Images come first, in the same order
+// as Assets, followed by the chunks that failed to decode and the unsupported ones.
+func (i *ICNS) Summary() Summary {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	s := Summary{
+		MinCompatibility: i.minCompat,
+		MaxCompatibility: i.maxCompat,
+	}
+
+	for _, a := range i.sortedAssets() {
+		w, h := int(a.Resolution), int(a.Resolution)
+		// don't decode anything just for that.
+		im := a.img.decoded()
+		if im != nil {
+			w, h = im.Bounds().Dx(), im.Bounds().Dy()
+		}
+
+		if m := a.img.format.combineCode; m != 0 {
+			s.Chunks = append(s.Chunks, ChunkSummary{
+				Type:          m,
+				Size:          a.img.maskSize,
+				Supported:     true,
+				Mask:          true,
+				Width:         w,
+				Height:        h,
+				Compatibility: compatRef(a.Compatibility),
+				Scale:         a.Scale,
+			})
+		}
+
+		s.Chunks = append(s.Chunks, ChunkSummary{
+			Type:          a.Type,
+			Size:          a.EncodedSize,
+			Supported:     true,
+			Encoding:      a.Encoding,
+			Width:         w,
+			Height:        h,
+			ColorModel:    colorModelName(im),
+			ColorSpace:    a.ColorSpace.Name,
+			Compatibility: compatRef(a.Compatibility),
+			Scale:         a.Scale,
+		})
+	}
+
+	for _, c := range i.failed {
+		cs := ChunkSummary{
+			Type:      c.code,
+			Size:      c.size,
+			Supported: true,
+			Error:     c.err.Error(),
+		}
+		if f, ok := imageFormats()[c.code]; ok {
+			cs.Compatibility, cs.Scale = compatRef(f.compat), f.scale
+		} else if f, ok := maskFormats()[c.code]; ok {
+			cs.Compatibility, cs.Scale, cs.Mask = compatRef(f.compat), f.scale, true
+		}
+		s.Chunks = append(s.Chunks, cs)
+	}
+
+	for _, c := range i.unsupported {
+		s.Chunks = append(s.Chunks, ChunkSummary{
+			Type: c.code,
+			Size: c.size,
+		})
+	}
+	return s
+}
+
+// String renders the summary as text, one line per image. Masks are omitted.
+// Chunks that failed to decode are counted separately.
+func (s Summary) String() string {
+	buf := new(bytes.Buffer)
+
+	n, failed := 0, 0
+	for _, c := range s.Chunks {
+		switch {
+		case c.Mask:
+		case c.Error != "":
+			failed++
+		default:
+			n++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(buf, "%d images, %d undecodable:\n", n, failed)
+	} else {
+		fmt.Fprintf(buf, "%d images:\n", n)
+	}
+	for _, c := range s.Chunks {
+		switch {
+		case c.Mask:
+		case !c.Supported:
+			fmt.Fprintf(buf, "[%s] unsupported image format\n", c.Type)
+		case c.Error != "":
+			fmt.Fprintf(buf, "[%s] undecodable image: %s\n", c.Type, c.Error)
+		default:
+			fmt.Fprintf(buf, "[%s] %s image with resolution %d\n", c.Type, c.Encoding, c.Width)
+		}
+	}
+	return buf.String()
+}
+
+// Info provides information about the ICNS
+func (i *ICNS) Info() string {
+	return i.Summary().String()
+}
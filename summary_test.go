@@ -0,0 +1,122 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSummary(t *testing.T) {
+	t.Parallel()
+	body, err := ioutil.ReadAll(testdataFileReader(t, "mit.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// truncate the RLE data of ic04, and drop the PNG signature from ic07.
+	idx := bytes.Index(body, []byte("ic04"))
+	copy(body[idx+12:], make([]byte, 777))
+	idx = bytes.Index(body, []byte("ic07"))
+	copy(body[idx+8:], "garbage!")
+
+	icon, err := readICNS(context.Background(), body, false, NewICNS())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := icon.Summary()
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Summary
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(s, got); diff != "" {
+		t.Errorf("JSON round trip mismatch (-want +got):\n%s", diff)
+	}
+
+	want := map[OSType]ChunkSummary{
+		ic12: {Type: ic12, Size: 3429, Supported: true, Encoding: EncodingPNG, Width: 64, Height: 64, ColorModel: "NRGBA", ColorSpace: "sRGB", Compatibility: compatRef(MountainLion), Scale: 2},
+	}
+	var failed, unsupported int
+	for _, c := range got.Chunks {
+		if w, ok := want[c.Type]; ok {
+			if diff := cmp.Diff(w, c); diff != "" {
+				t.Errorf("%s mismatch (-want +got):\n%s", c.Type, diff)
+			}
+		}
+		if c.Error != "" {
+			failed++
+		}
+		if !c.Supported {
+			unsupported++
+			if c.Compatibility != nil {
+				t.Errorf("%s: unsupported chunk reports compatibility %s", c.Type, *c.Compatibility)
+			}
+		}
+	}
+	if failed != 2 || unsupported != 1 {
+		t.Errorf("unexpected chunks: got %d failed and %d unsupported, want 2 and 1", failed, unsupported)
+	}
+
+	info := icon.Info()
+	for _, line := range []string{
+		"9 images, 2 undecodable:\n",
+		"[ic12] png image with resolution 64\n",
+		"[ic07] undecodable image: chunk ic07 at offset",
+		"[info] unsupported image format\n",
+	} {
+		if !strings.Contains(info, line) {
+			t.Errorf("missing %q in Info():\n%s", line, info)
+		}
+	}
+
+	// failed chunks are removed by their own type, masks included.
+	icon.failed = append(icon.failed, &chunk{code: s8mk, size: 8, err: ErrFormat})
+	for _, c := range []OSType{ic07, s8mk} {
+		if !icon.Remove(c) {
+			t.Errorf("Remove(%s) = false, want true", c)
+		}
+	}
+	if len(icon.failed) != 1 || icon.failed[0].code != ic04 {
+		t.Errorf("unexpected failed chunks after Remove: %v", icon.failed)
+	}
+}
+
+func TestSummaryMetadataOnly(t *testing.T) {
+	t.Parallel()
+	body, err := ioutil.ReadAll(testdataFileReader(t, "mit.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	icon, err := readICNS(context.Background(), body, true, NewICNS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(icon.Info(), "11 images:\n") {
+		t.Errorf("unexpected Info():\n%s", icon.Info())
+	}
+}
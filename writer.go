@@ -16,65 +16,335 @@ package icns
 
 import (
 	"bytes"
+	"context"
+	"image"
 	"io"
+	"time"
 
 	"yrh.dev/icns/internal/binary"
-	"yrh.dev/icns/internal/utils"
+	"yrh.dev/icns/internal/colorspace"
 )
 
-// Encode writes a .icns file to the provided writer.
-func Encode(w io.Writer, i *ICNS) error {
-	buffers := make([]*bytes.Buffer, 0)
-	sizes := make([]uint32, 0)
-	types := make([]uint32, 0)
-	var totalSize uint32 = 8
+// encodeImage encodes the image data for the provided format, according to the icon policy.
+// PNG data is tagged with the provided color space chunks.
+func (i *ICNS) encodeImage(ctx context.Context, b budget, f *format, im image.Image, tags []colorspace.Chunk) (*bytes.Buffer, error) {
+	if i.smallest {
+		return i.encodeSmallest(ctx, b, f, im, tags)
+	}
+
+	enc, err := i.encodingFor(f)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, a := range i.assets {
-		encoder := a.format.codec.Encode
-		if encoder == nil {
-			continue
+	if enc == EncodingPNG {
+		im = i.quantize(f, im)
+	}
+
+	c := f.codecFor(enc)
+	if enc == EncodingPNG && !f.custom {
+		c = tagged(c, tags)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := c.Encode(ctxWriter{ctx, buf}, im); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// encodedChunk is the output of Encode for a single chunk.
+type encodedChunk struct {
+	code OSType
+	buf  *bytes.Buffer
+}
+
+// encodeAsset encodes a representation, returning its mask chunk first if any.
+// On failure, it returns the type of the chunk that failed along with the error.
+func (i *ICNS) encodeAsset(ctx context.Context, b budget, a *img, t *tracker) ([]encodedChunk, OSType, error) {
+	if a.raw == nil && a.image() == nil {
+		return nil, a.format.code, ErrNoImage
+	}
+
+	var res []encodedChunk
+
+	// encode mask first
+	if a.format.combineCode != 0 {
+		// encode alpha channel as separated mask
+		mformat := maskFormats()[a.format.combineCode]
+		start := time.Now()
+		buf := new(bytes.Buffer)
+		if err := mformat.codec.Encode(ctxWriter{ctx, buf}, a.toNRGBA()); err != nil {
+			return nil, mformat.code, err
 		}
+		res = append(res, encodedChunk{mformat.code, buf})
+		t.report(mformat.code, buf.Len(), start)
+	}
 
-		// encode mask first
+	// pre-encoded data is written as is.
+	start := time.Now()
+	buf := bytes.NewBuffer(a.raw)
+	if a.raw == nil {
+		// the legacy encoders expect an NRGBA instance.
+		im := a.image()
 		if a.format.combineCode != 0 {
-			// the encoders expect an NRGBA instance
-			a.Image = utils.Img2NRGBA(a.Image)
+			im = a.toNRGBA()
+		}
 
-			// encode alpha channel as separated mask
-			mformat := supportedMaskFormats[a.format.combineCode]
-			buf := new(bytes.Buffer)
-			if err := mformat.codec.Encode(buf, a.Image); err != nil {
-				return err
-			}
-			size := uint32(buf.Len()) + 8
-			buffers = append(buffers, buf)
-			types = append(types, mformat.code)
-			sizes = append(sizes, size)
-			totalSize += size
+		var err error
+		if buf, err = i.encodeImage(ctx, b, a.format, im, a.color); err != nil {
+			return nil, a.format.code, err
 		}
+	}
+	t.report(a.format.code, buf.Len(), start)
+	return append(res, encodedChunk{a.format.code, buf}), 0, nil
+}
 
-		buf := new(bytes.Buffer)
-		if err := encoder(buf, a.Image); err != nil {
+// encodedAsset is the output of encodeAsset.
+type encodedAsset struct {
+	chunks []encodedChunk
+	// type of the chunk that failed, if any.
+	code OSType
+	err  error
+}
+
+// encodeAll encodes all the representations on the worker pool, and calls emit
+// with the results in order, as soon as each of them is available. It stops at
+// the first error returned by emit, or when the context is done.
+func (i *ICNS) encodeAll(ctx context.Context, emit func(encodedAsset) error) error {
+	assets := i.output()
+	n := len(assets)
+	t := i.tracker(EncodeOperation, chunkCount(assets))
+
+	results := make([]encodedAsset, n)
+	done := make([]chan struct{}, n)
+	for idx := range done {
+		done[idx] = make(chan struct{})
+	}
+
+	// the slots left by the chunk workers go to the smallest output candidates.
+	b := newBudget(i.workers())
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		parallel(n, i.workers(), func(idx int) {
+			defer close(done[idx])
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			default:
+			}
+			b.acquire()
+			defer b.release()
+			r := &results[idx]
+			r.chunks, r.code, r.err = i.encodeAsset(ctx, b, assets[idx], t)
+		})
+	}()
+	defer func() {
+		close(stop)
+		<-finished
+	}()
+
+	for idx := range results {
+		<-done[idx]
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := emit(results[idx]); err != nil {
 			return err
 		}
-		size := uint32(buf.Len()) + 8
-		buffers = append(buffers, buf)
-		types = append(types, a.format.code)
-		sizes = append(sizes, size)
-		totalSize += size
+		// the data is out, don't keep it around.
+		results[idx] = encodedAsset{}
+	}
+	return nil
+}
+
+// chunkCount returns the number of chunks holding the representations.
+func chunkCount(assets []*img) int {
+	n := len(assets)
+	for _, a := range assets {
+		if a.format.combineCode != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// tocEntry is an entry of the table of contents.
+type tocEntry struct {
+	code OSType
+	size uint32
+}
+
+// tocData returns the content of the table of contents chunk.
+func tocData(entries []tocEntry) []byte {
+	res := make([]byte, 8*len(entries))
+	wd := binary.Writer(res)
+	for _, e := range entries {
+		wd.Uint32(uint32(e.code))
+		wd.Uint32(e.size)
 	}
+	return res
+}
 
-	data := make([]byte, totalSize)
-	wd := binary.Writer(data)
-	wd.Uint32(magic)
-	wd.Uint32(totalSize)
+// writeChunk writes a chunk header followed by its data.
+func writeChunk(w io.Writer, code OSType, size uint32, data []byte) (int64, error) {
+	hdr := make([]byte, 8)
+	wd := binary.Writer(hdr)
+	wd.Uint32(uint32(code))
+	wd.Uint32(size)
 
-	for idx := range buffers {
-		wd.Uint32(types[idx])
-		wd.Uint32(sizes[idx])
-		wd.Section(buffers[idx].Bytes())
+	n, err := w.Write(hdr)
+	if err != nil {
+		return int64(n), err
 	}
+	m, err := w.Write(data)
+	return int64(n + m), err
+}
+
+// Encode writes a .icns file to the provided writer.
+// It doesn't modify the icon, and can be called concurrently on the same value.
+// Representations are encoded in parallel, see WithParallelism, and written out
+// as soon as possible if the writer is seekable. See WriteTo for details.
+func Encode(w io.Writer, i *ICNS) error {
+	return EncodeContext(context.Background(), w, i)
+}
 
-	_, err := w.Write(data)
+// EncodeContext is like Encode, but stops as soon as possible when the context is
+// done, returning the context error.
+func EncodeContext(ctx context.Context, w io.Writer, i *ICNS) error {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	_, err := i.writeTo(ctx, w)
 	return err
 }
+
+// WriteTo writes the icon to w in .icns format, and returns the number of bytes
+// written. It implements io.WriterTo.
+//
+// If w is a seekable io.WriteSeeker, each chunk is written as soon as it's encoded,
+// and the file size is patched in the header at the end. Otherwise, the whole icon
+// is encoded first, since the header must hold the file size. In both cases, no
+// other copy of the output is made.
+// In streaming mode, a failure can leave incomplete data in w.
+func (i *ICNS) WriteTo(w io.Writer) (int64, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.writeTo(context.Background(), w)
+}
+
+func (i *ICNS) writeTo(ctx context.Context, w io.Writer) (int64, error) {
+	// pipes and terminals are not seekable, even as files.
+	if ws, ok := w.(io.WriteSeeker); ok {
+		if start, err := ws.Seek(0, io.SeekCurrent); err == nil {
+			return i.stream(ctx, ws, start)
+		}
+	}
+
+	var chunks []encodedChunk
+	var entries []tocEntry
+	var totalSize uint32 = 8
+	if i.toc {
+		totalSize += 8 + 8*uint32(chunkCount(i.output()))
+	}
+	err := i.encodeAll(ctx, func(r encodedAsset) error {
+		if r.err != nil {
+			return &ChunkError{Type: r.code, Offset: int64(totalSize), Err: r.err}
+		}
+		for _, c := range r.chunks {
+			chunks = append(chunks, c)
+			entries = append(entries, tocEntry{c.code, uint32(c.buf.Len()) + 8})
+			totalSize += uint32(c.buf.Len()) + 8
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := writeChunk(w, magic, totalSize, nil)
+	if err != nil {
+		return written, err
+	}
+	if i.toc {
+		data := tocData(entries)
+		n, err := writeChunk(w, toc, uint32(len(data))+8, data)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	for idx, c := range chunks {
+		n, err := writeChunk(w, c.code, uint32(c.buf.Len())+8, c.buf.Bytes())
+		written += n
+		if err != nil {
+			return written, err
+		}
+		chunks[idx] = encodedChunk{}
+	}
+	return written, nil
+}
+
+// stream writes the chunks as they're encoded from the start position, and patches
+// the file size at the end.
+func (i *ICNS) stream(ctx context.Context, w io.WriteSeeker, start int64) (int64, error) {
+	// the size is unknown yet.
+	written, err := writeChunk(w, magic, 0, nil)
+	if err != nil {
+		return written, err
+	}
+
+	// so are the sizes listed in the table of contents, but not its size.
+	var entries []tocEntry
+	if i.toc {
+		data := make([]byte, 8*chunkCount(i.output()))
+		n, err := writeChunk(w, toc, uint32(len(data))+8, data)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	err = i.encodeAll(ctx, func(r encodedAsset) error {
+		if r.err != nil {
+			return &ChunkError{Type: r.code, Offset: written, Err: r.err}
+		}
+		for _, c := range r.chunks {
+			entries = append(entries, tocEntry{c.code, uint32(c.buf.Len()) + 8})
+			n, err := writeChunk(w, c.code, uint32(c.buf.Len())+8, c.buf.Bytes())
+			written += n
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return written, err
+	}
+
+	size := make([]byte, 4)
+	wd := binary.Writer(size)
+	wd.Uint32(uint32(written))
+	if _, err := w.Seek(start+4, io.SeekStart); err != nil {
+		return written, err
+	}
+	if _, err := w.Write(size); err != nil {
+		return written, err
+	}
+	if i.toc {
+		if _, err := w.Seek(start+16, io.SeekStart); err != nil {
+			return written, err
+		}
+		if _, err := w.Write(tocData(entries)); err != nil {
+			return written, err
+		}
+	}
+	_, err = w.Seek(start+written, io.SeekStart)
+	return written, err
+}
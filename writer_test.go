@@ -0,0 +1,131 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package icns
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	t.Parallel()
+
+	icon, err := Decode(testdataFileReader(t, "mit.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var _ io.WriterTo = icon
+
+	buf := new(bytes.Buffer)
+	n, err := icon.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo() returned %d, want %d", n, buf.Len())
+	}
+	want := buf.Bytes()
+
+	// streaming to a seekable writer, not positioned at its start.
+	f, err := ioutil.TempFile("", "icns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	prefix := []byte("prefix")
+	if _, err := f.Write(prefix); err != nil {
+		t.Fatal(err)
+	}
+	if n, err = icon.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(want)) {
+		t.Errorf("WriteTo() returned %d, want %d", n, len(want))
+	}
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != int64(len(prefix)+len(want)) {
+		t.Errorf("unexpected position after WriteTo(): got %d, want %d", pos, len(prefix)+len(want))
+	}
+
+	got, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, append(prefix, want...)) {
+		t.Errorf("streamed output differs from buffered output")
+	}
+}
+
+func TestWriteToPipe(t *testing.T) {
+	t.Parallel()
+
+	icon, err := Decode(testdataFileReader(t, "mit.icns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, icon); err != nil {
+		t.Fatal(err)
+	}
+
+	// a pipe is an *os.File, but it can't seek.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	done := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		done <- data
+	}()
+
+	err = Encode(w, icon)
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-done; !bytes.Equal(got, buf.Bytes()) {
+		t.Errorf("output written to a pipe differs from buffered output")
+	}
+}
+
+func TestWriteToFailure(t *testing.T) {
+	t.Parallel()
+
+	icon := NewICNS()
+	if err := icon.Add(testImage(32)); err != nil {
+		t.Fatal(err)
+	}
+	icon.assets = append(icon.assets, &img{format: supportedImageFormats[ic07]})
+
+	buf := new(bytes.Buffer)
+	_, err := icon.WriteTo(buf)
+	var cerr *ChunkError
+	if !errors.As(err, &cerr) || cerr.Type != ic07 || !errors.Is(err, ErrNoImage) {
+		t.Fatalf("WriteTo() returned %v, want a ChunkError for ic07", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("WriteTo() wrote %d bytes on failure, want none", buf.Len())
+	}
+}